@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// nodeAlias pairs a Teleport node hostname with the SSH host alias written for it
+type nodeAlias struct {
+	Alias    string
+	Hostname string
+	// Original is the name the node is also reachable by when Alias comes
+	// from alias_template; empty otherwise
+	Original string
+	// User is the node's login when it differs from the cluster default
+	User string
+}
+
+// aliasTemplateData is what alias_template can refer to
+type aliasTemplateData struct {
+	Hostname string
+	Cluster  string
+	Labels   map[string]string
+}
+
+// parseAliasTemplate parses an alias_template setting; missing labels render empty
+func parseAliasTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("alias_template").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alias_template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// renderAlias builds a node's alias from tmpl. Characters that can't appear
+// in a host alias become '-', and separators left over from empty labels are
+// collapsed, so "{{.Labels.env}}-{{.Hostname}}" gives "web-1" without env.
+func renderAlias(tmpl *template.Template, data aliasTemplateData) (string, error) {
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+
+	var alias strings.Builder
+	for _, r := range out.String() {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.':
+			alias.WriteRune(r)
+		default:
+			alias.WriteRune('-')
+		}
+	}
+
+	name := alias.String()
+	for strings.Contains(name, "--") {
+		name = strings.ReplaceAll(name, "--", "-")
+	}
+	name = strings.Trim(name, "-.")
+	if err := validateNodeName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// uniqueNodes returns nodes without duplicate hostnames, in order
+func uniqueNodes(nodes []tshNode) []tshNode {
+	seen := map[string]bool{}
+	var unique []tshNode
+	for _, node := range nodes {
+		if !seen[node.Hostname] {
+			seen[node.Hostname] = true
+			unique = append(unique, node)
+		}
+	}
+	return unique
+}
+
+// assignNodeAliases picks the SSH alias of every node, keyed by cluster name.
+// kept holds the aliases of the clusters whose previous section is kept
+// because we are not logged in to them. Hostnames that exist in more than one
+// cluster, or are already an alias of a kept cluster, are namespaced as
+// <cluster>.<hostname>. With alias_template set, each node is named by the
+// template and keeps that name as a second alias; nodes whose templated
+// names collide keep only their name.
+func assignNodeAliases(states []clusterState, kept map[string][]nodeAlias) (map[string][]nodeAlias, error) {
+	seen := map[string]int{}
+	for _, state := range states {
+		for _, node := range uniqueNodes(state.nodes) {
+			seen[node.Hostname]++
+		}
+	}
+
+	// Names in kept sections stay in the config and can't be reused
+	reserved := map[string]bool{}
+	for _, aliases := range kept {
+		hostnames := map[string]bool{}
+		for _, alias := range aliases {
+			if !hostnames[alias.Hostname] {
+				hostnames[alias.Hostname] = true
+				seen[alias.Hostname]++
+			}
+			reserved[alias.Alias] = true
+			if alias.Original != "" {
+				reserved[alias.Original] = true
+			}
+		}
+	}
+
+	var tmpl *template.Template
+	if cfg.AliasTemplate != "" {
+		var err error
+		if tmpl, err = parseAliasTemplate(cfg.AliasTemplate); err != nil {
+			return nil, err
+		}
+	}
+
+	// Name every node, then look for collisions across all clusters
+	type candidate struct {
+		cluster string
+		alias   nodeAlias
+	}
+	var candidates []candidate
+	taken := map[string]int{}
+	for name := range reserved {
+		taken[name]++
+	}
+	for _, state := range states {
+		for _, node := range uniqueNodes(state.nodes) {
+			name := node.Hostname
+			if seen[node.Hostname] > 1 || reserved[name] {
+				name = state.cluster.Name + "." + node.Hostname
+			}
+			if err := validateNodeName(name); err != nil {
+				fmt.Printf("Warning: skipping alias %q: %v\n", name, err)
+				continue
+			}
+
+			alias := nodeAlias{Alias: name, Hostname: node.Hostname}
+			if tmpl != nil {
+				templated, err := renderAlias(tmpl, aliasTemplateData{
+					Hostname: node.Hostname,
+					Cluster:  state.cluster.Name,
+					Labels:   node.Labels,
+				})
+				if err != nil {
+					fmt.Printf("Warning: alias_template for %s: %v; using %s\n", node.Hostname, err, name)
+				} else if templated != name {
+					alias = nodeAlias{Alias: templated, Hostname: node.Hostname, Original: name}
+					taken[templated]++
+				}
+			}
+			taken[name]++
+			candidates = append(candidates, candidate{cluster: state.cluster.Name, alias: alias})
+		}
+	}
+
+	aliases := map[string][]nodeAlias{}
+	for _, c := range candidates {
+		alias := c.alias
+		if alias.Original != "" && taken[alias.Alias] > 1 {
+			fmt.Printf("Warning: alias %q is not unique; using %s\n", alias.Alias, alias.Original)
+			alias = nodeAlias{Alias: alias.Original, Hostname: alias.Hostname}
+		}
+		aliases[c.cluster] = append(aliases[c.cluster], alias)
+	}
+	return aliases, nil
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderAlias(t *testing.T) {
+	tests := []struct {
+		template string
+		data     aliasTemplateData
+		want     string
+		wantErr  bool
+	}{
+		{"{{.Labels.env}}-{{.Hostname}}", aliasTemplateData{Hostname: "web-1", Labels: map[string]string{"env": "prod"}}, "prod-web-1", false},
+		{"{{.Labels.env}}-{{.Hostname}}", aliasTemplateData{Hostname: "web-1"}, "web-1", false},
+		{"{{.Cluster}}.{{.Hostname}}", aliasTemplateData{Hostname: "web-1", Cluster: "staging"}, "staging.web-1", false},
+		{"{{.Labels.team}}/{{.Hostname}}", aliasTemplateData{Hostname: "gpu 1", Labels: map[string]string{"team": "ML Ops"}}, "ML-Ops-gpu-1", false},
+		{"{{.Labels.env}}", aliasTemplateData{Hostname: "web-1"}, "", true},
+		{"{{.Labels.env}}", aliasTemplateData{Hostname: "web-1", Labels: map[string]string{"env": "*"}}, "", true},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseAliasTemplate(tt.template)
+		if err != nil {
+			t.Fatalf("parseAliasTemplate(%q) = %v", tt.template, err)
+		}
+		got, err := renderAlias(tmpl, tt.data)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("renderAlias(%q, %+v) = %q, %v, want %q (error %v)", tt.template, tt.data, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseAliasTemplateInvalid(t *testing.T) {
+	for _, text := range []string{"{{.Hostname", "{{.Hostname | nosuchfunc}}", "{{end}}"} {
+		if _, err := parseAliasTemplate(text); err == nil || !strings.Contains(err.Error(), "invalid alias_template") {
+			t.Errorf("parseAliasTemplate(%q) = %v, want an invalid alias_template error", text, err)
+		}
+	}
+
+	useTestEnv(t, &fakeTsh{})
+	cfg.AliasTemplate = "{{.Hostname"
+	if _, err := assignNodeAliases([]clusterState{testClusterState("web-1")}, nil); err == nil {
+		t.Error("assignNodeAliases() with an invalid template succeeded")
+	}
+}
+
+func TestAssignNodeAliasesTemplate(t *testing.T) {
+	useTestEnv(t, &fakeTsh{})
+	cfg.AliasTemplate = "{{.Labels.role}}"
+
+	state := testClusterState()
+	state.nodes = []tshNode{
+		{Hostname: "ip-10-0-0-1", Labels: map[string]string{"role": "db"}},
+		{Hostname: "ip-10-0-0-2", Labels: map[string]string{"role": "web"}},
+		{Hostname: "ip-10-0-0-3", Labels: map[string]string{"role": "web"}},
+		{Hostname: "ip-10-0-0-4"},
+		{Hostname: "web", Labels: map[string]string{"role": "cache"}},
+	}
+
+	var aliases map[string][]nodeAlias
+	output := captureOutput(t, func() {
+		var err error
+		if aliases, err = assignNodeAliases([]clusterState{state}, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	want := []nodeAlias{
+		{Alias: "db", Hostname: "ip-10-0-0-1", Original: "ip-10-0-0-1"},
+		// Both render "web", which is also a hostname: all keep their name
+		{Alias: "ip-10-0-0-2", Hostname: "ip-10-0-0-2"},
+		{Alias: "ip-10-0-0-3", Hostname: "ip-10-0-0-3"},
+		// Renders empty: falls back to the hostname
+		{Alias: "ip-10-0-0-4", Hostname: "ip-10-0-0-4"},
+		{Alias: "cache", Hostname: "web", Original: "web"},
+	}
+	got := aliases["default"]
+	if len(got) != len(want) {
+		t.Fatalf("got %d aliases %+v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("alias %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	for _, line := range []string{
+		`Warning: alias "web" is not unique; using ip-10-0-0-2`,
+		"Warning: alias_template for ip-10-0-0-4:",
+	} {
+		if !strings.Contains(output, line) {
+			t.Errorf("output is missing %q:\n%s", line, output)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+// writeOptions controls how changes to user files are applied
+type writeOptions struct {
+	dryRun       bool // print the diff without writing anything
+	confirm      bool // print the diff and ask before writing (menu mode)
+	fixConflicts bool // comment out conflicting ~/.ssh/config lines without asking
+}
+
+// fileChange is a pending rewrite of a user file
+type fileChange struct {
+	path    string
+	oldData []byte // nil if the file does not exist yet
+	newData []byte
+	perm    os.FileMode
+	// backup is called right before the file is written, if set
+	backup func() error
+}
+
+// changeResult is what applyFileChange did with a change
+type changeResult int
+
+const (
+	changeUnchanged changeResult = iota // the file already had the new contents
+	changeDryRun                        // the diff was printed, nothing written
+	changeDeclined                      // the user said no at the prompt
+	changeWritten
+)
+
+// confirmChange asks whether to write the previewed change to path
+var confirmChange = func(path string) bool {
+	apply := false
+	prompt := &survey.Confirm{
+		Message: fmt.Sprintf("Apply these changes to %s?", path),
+		Default: true,
+	}
+	if err := survey.AskOne(prompt, &apply); err != nil {
+		return false
+	}
+	return apply
+}
+
+// applyFileChange previews and writes a change according to opts, creating
+// missing parent directories with mode 0700. It reports what it did.
+func applyFileChange(change fileChange, opts writeOptions) (changeResult, error) {
+	if change.oldData != nil && string(change.oldData) == string(change.newData) {
+		fmt.Printf("No changes to %s\n", change.path)
+		return changeUnchanged, nil
+	}
+
+	if opts.dryRun || opts.confirm {
+		oldName := change.path
+		if change.oldData == nil {
+			oldName = "/dev/null"
+		}
+		fmt.Println()
+		fmt.Print(unifiedDiff(oldName, change.path+" (new)", string(change.oldData), string(change.newData)))
+		fmt.Println()
+	}
+
+	if opts.dryRun {
+		fmt.Printf("Dry run: %s was not modified\n", change.path)
+		return changeDryRun, nil
+	}
+
+	if opts.confirm && !confirmChange(change.path) {
+		fmt.Printf("Skipped %s\n", change.path)
+		return changeDeclined, nil
+	}
+
+	if change.backup != nil {
+		if err := change.backup(); err != nil {
+			return changeUnchanged, err
+		}
+	}
+
+	// Only now, so a dry run leaves the filesystem untouched
+	if err := os.MkdirAll(filepath.Dir(change.path), 0700); err != nil {
+		return changeUnchanged, fmt.Errorf("failed to create %s: %v", filepath.Dir(change.path), err)
+	}
+	if err := writeFileAtomic(change.path, change.newData, change.perm); err != nil {
+		return changeUnchanged, fmt.Errorf("failed to write %s: %v", change.path, err)
+	}
+	return changeWritten, nil
+}
@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// backupEntry describes one saved version of a user file
+type backupEntry struct {
+	ID       string      `json:"id"`
+	Source   string      `json:"source"`
+	Time     time.Time   `json:"time"`
+	Reason   string      `json:"reason"`
+	Checksum string      `json:"sha256"`
+	Mode     os.FileMode `json:"mode"`
+}
+
+// backupManifest is the index of the backup store
+type backupManifest struct {
+	Backups []backupEntry `json:"backups"`
+}
+
+// backupDir returns the backup store under the state directory
+func backupDir() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "backups")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %v", err)
+	}
+	return dir, nil
+}
+
+// backupFile returns where the contents of a backup are stored
+func backupFile(dir string, entry backupEntry) string {
+	return filepath.Join(dir, entry.ID+".bak")
+}
+
+// backupManifestFile is the manifest of the backup store in the state directory
+var backupManifestFile = filepath.Join("backups", "manifest.json")
+
+// readBackupManifest loads the manifest; a missing manifest is empty
+func readBackupManifest() (*backupManifest, error) {
+	manifest := &backupManifest{}
+	if err := loadJSONState(backupManifestFile, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// writeBackupManifest saves the manifest
+func writeBackupManifest(manifest *backupManifest) error {
+	return saveJSONState(backupManifestFile, manifest)
+}
+
+// checksum returns the hex SHA-256 of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// saveBackup stores data as the current version of source and prunes old
+// backups of source beyond the configured retention. Saving the same
+// contents as the newest backup of source is a no-op.
+func saveBackup(source string, data []byte, mode os.FileMode, reason string) (*backupEntry, error) {
+	unlock, err := acquireLock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dir, err := backupDir()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readBackupManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := checksum(data)
+	for i := len(manifest.Backups) - 1; i >= 0; i-- {
+		if manifest.Backups[i].Source == source {
+			if manifest.Backups[i].Checksum == sum {
+				return &manifest.Backups[i], nil
+			}
+			break
+		}
+	}
+
+	// The source is part of the ID so identical files backed up together differ
+	now := time.Now()
+	entry := backupEntry{
+		ID:       now.Format("20060102-150405") + "-" + checksum([]byte(source + "\x00" + sum))[:8],
+		Source:   source,
+		Time:     now,
+		Reason:   reason,
+		Checksum: sum,
+		Mode:     mode.Perm(),
+	}
+	if err := writeFileAtomic(backupFile(dir, entry), data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write backup: %v", err)
+	}
+	manifest.Backups = append(manifest.Backups, entry)
+
+	// Keep the newest BackupRetention backups of each file
+	var kept []backupEntry
+	count := 0
+	for i := len(manifest.Backups) - 1; i >= 0; i-- {
+		b := manifest.Backups[i]
+		if b.Source == source {
+			count++
+			if count > cfg.BackupRetention {
+				os.Remove(backupFile(dir, b))
+				continue
+			}
+		}
+		kept = append([]backupEntry{b}, kept...)
+	}
+	manifest.Backups = kept
+
+	if err := writeBackupManifest(manifest); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// restoreBackup writes the backup with the given ID back to its source file,
+// first backing up the file's current contents
+func restoreBackup(id string) (*backupEntry, error) {
+	unlock, err := acquireLock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dir, err := backupDir()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readBackupManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	var entry *backupEntry
+	for i := range manifest.Backups {
+		if manifest.Backups[i].ID == id {
+			entry = &manifest.Backups[i]
+			break
+		}
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("unknown backup %q (see 'scicom-helper backups list')", id)
+	}
+	restored := *entry
+
+	data, err := os.ReadFile(backupFile(dir, restored))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup %s: %v", id, err)
+	}
+	if checksum(data) != restored.Checksum {
+		return nil, fmt.Errorf("backup %s is corrupted (checksum mismatch)", id)
+	}
+
+	// Keep the version being replaced so the restore can be undone
+	if current, err := os.ReadFile(restored.Source); err == nil {
+		mode := os.FileMode(0600)
+		if info, err := os.Stat(restored.Source); err == nil {
+			mode = info.Mode()
+		}
+		if _, err := saveBackup(restored.Source, current, mode, "before restore of "+id); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %v", restored.Source, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(restored.Source), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", filepath.Dir(restored.Source), err)
+	}
+	if err := writeFileAtomic(restored.Source, data, restored.Mode); err != nil {
+		return nil, fmt.Errorf("failed to restore %s: %v", restored.Source, err)
+	}
+	// writeFileAtomic keeps the mode of the file it replaces
+	if err := os.Chmod(restored.Source, restored.Mode); err != nil {
+		return nil, fmt.Errorf("failed to restore the mode of %s: %v", restored.Source, err)
+	}
+	return &restored, nil
+}
+
+var backupsCmd = &cobra.Command{
+	Use:   "backups",
+	Short: "List and restore backups of the SSH config and editor settings",
+	Long: `List and restore backups of the SSH config and editor settings.
+
+A backup is saved every time scicom-helper modifies one of your files.
+The newest backup_retention backups of each file are kept.`,
+}
+
+var backupsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List saved backups, newest first",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		manifest, err := readBackupManifest()
+		if err != nil {
+			return err
+		}
+
+		if len(manifest.Backups) == 0 {
+			fmt.Println("No backups yet")
+			return nil
+		}
+
+		backups := manifest.Backups
+		sort.SliceStable(backups, func(i, j int) bool {
+			return backups[i].Time.After(backups[j].Time)
+		})
+		for _, b := range backups {
+			fmt.Printf("%-24s  %s  %-20s  %s\n", b.ID, b.Time.Format("2006-01-02 15:04:05"), b.Reason, b.Source)
+		}
+		return nil
+	},
+}
+
+var backupsRestoreCmd = &cobra.Command{
+	Use:   "restore <id>",
+	Short: "Put a previous version of a file back",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entry, err := restoreBackup(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✓ Restored %s from backup %s (%s)\n", entry.Source, entry.ID, entry.Time.Format("2006-01-02 15:04:05"))
+		return nil
+	},
+}
+
+func init() {
+	backupsCmd.AddCommand(backupsListCmd)
+	backupsCmd.AddCommand(backupsRestoreCmd)
+	rootCmd.AddCommand(backupsCmd)
+}
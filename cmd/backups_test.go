@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// readManifest returns the backup manifest or fails the test
+func readManifest(t *testing.T) *backupManifest {
+	t.Helper()
+	manifest, err := readBackupManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+func TestSaveBackupDeduplicates(t *testing.T) {
+	home := useTestEnv(t, &fakeTsh{})
+	source := filepath.Join(home, ".ssh", "config")
+
+	first, err := saveBackup(source, []byte("a"), 0600, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := saveBackup(source, []byte("a"), 0600, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID || len(readManifest(t).Backups) != 1 {
+		t.Errorf("saving the same contents twice made a new backup: %s, %s", first.ID, again.ID)
+	}
+
+	// Only the newest backup of the file is compared
+	for _, data := range []string{"b", "a"} {
+		if _, err := saveBackup(source, []byte(data), 0600, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(readManifest(t).Backups); got != 3 {
+		t.Errorf("got %d backups after a, b, a; want 3", got)
+	}
+
+	// The same contents of another file is a separate backup
+	other, err := saveBackup(filepath.Join(home, "settings.json"), []byte("a"), 0600, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID == first.ID {
+		t.Errorf("backups of two files share the ID %s", other.ID)
+	}
+}
+
+func TestSaveBackupRetention(t *testing.T) {
+	home := useTestEnv(t, &fakeTsh{})
+	cfg.BackupRetention = 3
+	source := filepath.Join(home, ".ssh", "config")
+	other := filepath.Join(home, "settings.json")
+
+	if _, err := saveBackup(other, []byte("other"), 0600, "test"); err != nil {
+		t.Fatal(err)
+	}
+	var saved []*backupEntry
+	for i := 0; i < 5; i++ {
+		entry, err := saveBackup(source, []byte(fmt.Sprintf("version %d", i)), 0600, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, entry)
+	}
+
+	dir := filepath.Join(home, ".local", "state", "scicom-helper", "backups")
+	var kept []string
+	for _, entry := range readManifest(t).Backups {
+		kept = append(kept, entry.ID)
+		if _, err := os.Stat(backupFile(dir, entry)); err != nil {
+			t.Errorf("backup %s is in the manifest but not on disk: %v", entry.ID, err)
+		}
+	}
+	if len(kept) != 4 || strings.Join(kept[1:], ",") != saved[2].ID+","+saved[3].ID+","+saved[4].ID {
+		t.Errorf("kept %v, want the other file's backup and the newest 3 of %s", kept, source)
+	}
+	for _, pruned := range saved[:2] {
+		if _, err := os.Stat(backupFile(dir, *pruned)); !os.IsNotExist(err) {
+			t.Errorf("pruned backup %s is still on disk: %v", pruned.ID, err)
+		}
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	home := useTestEnv(t, &fakeTsh{})
+	source := filepath.Join(home, ".vscode", "settings.json")
+	if err := os.MkdirAll(filepath.Dir(source), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := saveBackup(source, []byte("original\n"), 0644, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if original.Mode != 0644 {
+		t.Errorf("backup mode = %v, want 0644", original.Mode)
+	}
+	if err := os.WriteFile(source, []byte("edited\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := restoreBackup(original.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ID != original.ID {
+		t.Errorf("restored %s, want %s", restored.ID, original.ID)
+	}
+	if got := readTestFile(t, source); got != "original\n" {
+		t.Errorf("restored contents %q, want original", got)
+	}
+	if info, err := os.Stat(source); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0644 {
+		t.Errorf("restored mode %v, want 0644", info.Mode().Perm())
+	}
+
+	// The replaced version was backed up first, so the restore can be undone
+	backups := readManifest(t).Backups
+	last := backups[len(backups)-1]
+	if len(backups) != 2 || last.Reason != "before restore of "+original.ID || last.Mode != 0600 {
+		t.Fatalf("backups after restore = %+v, want one of the edited file", backups)
+	}
+	if _, err := restoreBackup(last.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, source); got != "edited\n" {
+		t.Errorf("undoing the restore gave %q, want edited", got)
+	}
+}
+
+func TestRestoreBackupErrors(t *testing.T) {
+	home := useTestEnv(t, &fakeTsh{})
+	source := filepath.Join(home, "settings.json")
+	entry, err := saveBackup(source, []byte("original\n"), 0600, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := restoreBackup("no-such-backup"); err == nil || !strings.Contains(err.Error(), "unknown backup") {
+		t.Errorf("restoreBackup(unknown) = %v, want an unknown backup error", err)
+	}
+
+	dir := filepath.Join(home, ".local", "state", "scicom-helper", "backups")
+	if err := os.WriteFile(backupFile(dir, *entry), []byte("tampered\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restoreBackup(entry.ID); err == nil || !strings.Contains(err.Error(), "corrupted") {
+		t.Errorf("restoreBackup(corrupted) = %v, want a checksum error", err)
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("a corrupted backup was restored: %v", err)
+	}
+}
@@ -0,0 +1,371 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/spf13/cobra"
+)
+
+// defaultClusterName names the implicit cluster built from the top-level proxy setting
+const defaultClusterName = "default"
+
+// ClusterConfig is a named Teleport cluster profile
+type ClusterConfig struct {
+	Name          string `yaml:"name"`
+	Proxy         string `yaml:"proxy"`
+	AuthConnector string `yaml:"auth_connector,omitempty"`
+	SSHPort       int    `yaml:"ssh_port,omitempty"`
+}
+
+// clusterNamePattern restricts cluster names to characters that are safe in
+// SSH host aliases and marker comments
+var clusterNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// clusterProfiles returns all configured clusters with defaults filled in.
+// Without any clusters configured, the top-level proxy forms a single cluster.
+func (c *Config) clusterProfiles() []ClusterConfig {
+	if len(c.Clusters) == 0 {
+		return []ClusterConfig{{
+			Name:          defaultClusterName,
+			Proxy:         c.Proxy,
+			AuthConnector: c.AuthConnector,
+			SSHPort:       c.SSHPort,
+		}}
+	}
+
+	clusters := make([]ClusterConfig, len(c.Clusters))
+	for i, cluster := range c.Clusters {
+		if cluster.AuthConnector == "" {
+			cluster.AuthConnector = c.AuthConnector
+		}
+		if cluster.SSHPort == 0 {
+			cluster.SSHPort = c.SSHPort
+		}
+		clusters[i] = cluster
+	}
+	return clusters
+}
+
+// findCluster looks up a cluster profile by name
+func (c *Config) findCluster(name string) (ClusterConfig, error) {
+	for _, cluster := range c.clusterProfiles() {
+		if cluster.Name == name {
+			return cluster, nil
+		}
+	}
+	return ClusterConfig{}, fmt.Errorf("unknown cluster %q", name)
+}
+
+// activeCluster returns the cluster selected by active_cluster, or the first one
+func (c *Config) activeCluster() ClusterConfig {
+	clusters := c.clusterProfiles()
+	for _, cluster := range clusters {
+		if cluster.Name == c.ActiveCluster {
+			return cluster
+		}
+	}
+	return clusters[0]
+}
+
+// validateClusters checks cluster names, proxies and the active cluster
+func (c *Config) validateClusters() error {
+	seen := map[string]bool{}
+	for _, cluster := range c.Clusters {
+		if !clusterNamePattern.MatchString(cluster.Name) {
+			return fmt.Errorf("invalid cluster name %q (use letters, digits, '-' and '_')", cluster.Name)
+		}
+		if seen[cluster.Name] {
+			return fmt.Errorf("duplicate cluster name %q", cluster.Name)
+		}
+		seen[cluster.Name] = true
+
+		if cluster.Proxy == "" {
+			return fmt.Errorf("cluster %q has no proxy", cluster.Name)
+		}
+		if err := validateProxy(cluster.Proxy); err != nil {
+			return fmt.Errorf("cluster %q: %v", cluster.Name, err)
+		}
+		if cluster.SSHPort < 0 || cluster.SSHPort > 65535 {
+			return fmt.Errorf("cluster %q: ssh_port must be between 1 and 65535, got %d", cluster.Name, cluster.SSHPort)
+		}
+	}
+
+	if c.ActiveCluster != "" {
+		if _, err := c.findCluster(c.ActiveCluster); err != nil {
+			return fmt.Errorf("active_cluster: %v", err)
+		}
+	}
+	return nil
+}
+
+// loginToCluster logs in to a cluster, or switches to its profile if still valid
+func loginToCluster(cluster ClusterConfig, auth string) error {
+	if status, err := tshClient.Status(cluster.Proxy); err == nil {
+		fmt.Printf("✓ Already logged in to %s as %s\n", cluster.Name, status.Username)
+		// Make it the active tsh profile as well
+		return tshClient.Login(cluster.Proxy, auth)
+	}
+
+	fmt.Printf("Logging in to %s (%s)...\n", cluster.Name, cluster.Proxy)
+	if err := tshClient.Login(cluster.Proxy, auth); err != nil {
+		return fmt.Errorf("failed to log in to %s: %v", cluster.Name, err)
+	}
+	return nil
+}
+
+// switchCluster makes name the active cluster in the config file and in tsh
+func switchCluster(name string) error {
+	cluster, err := cfg.findCluster(name)
+	if err != nil {
+		return err
+	}
+
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := acquireLock()
+	if err != nil {
+		return err
+	}
+	fileConfig := &Config{}
+	err = readConfigFile(path, fileConfig)
+	if err == nil {
+		fileConfig.ActiveCluster = cluster.Name
+		err = writeConfigFile(path, fileConfig)
+	}
+	// Don't hold the lock during the interactive login
+	unlock()
+	if err != nil {
+		return err
+	}
+	cfg.ActiveCluster = cluster.Name
+
+	if err := loginToCluster(cluster, cluster.AuthConnector); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Active cluster is now %s (%s)\n", cluster.Name, cluster.Proxy)
+	return nil
+}
+
+// selectCluster lets the user pick and switch the active cluster from the menu
+func selectCluster() error {
+	clusters := cfg.clusterProfiles()
+	if len(clusters) < 2 {
+		fmt.Println("Only one cluster is configured. Add more with 'scicom-helper clusters add'.")
+		return nil
+	}
+
+	var options []string
+	for _, cluster := range clusters {
+		options = append(options, cluster.Name)
+	}
+
+	var choice string
+	prompt := &survey.Select{
+		Message: "Select the active Teleport cluster:",
+		Options: options,
+		Default: cfg.activeCluster().Name,
+		Description: func(value string, index int) string {
+			return clusters[index].Proxy
+		},
+	}
+	if err := survey.AskOne(prompt, &choice); err != nil {
+		return fmt.Errorf("selection cancelled")
+	}
+
+	return switchCluster(choice)
+}
+
+var clustersCmd = &cobra.Command{
+	Use:   "clusters",
+	Short: "Manage Teleport cluster profiles",
+	Long: `Manage Teleport cluster profiles.
+
+Clusters are configured under "clusters" in the config file. When none are
+configured, the top-level proxy setting is used as a single cluster named "default".`,
+}
+
+var clustersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List cluster profiles and their login status",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		active := cfg.activeCluster().Name
+		for _, cluster := range cfg.clusterProfiles() {
+			marker := " "
+			if cluster.Name == active {
+				marker = "*"
+			}
+
+			state := "not logged in"
+			if status, err := tshClient.Status(cluster.Proxy); err == nil {
+				state = fmt.Sprintf("logged in as %s", status.Username)
+				if !status.ValidUntil.IsZero() {
+					state += fmt.Sprintf(", valid until %s", status.ValidUntil.Local().Format(time.Kitchen))
+				}
+			}
+
+			fmt.Printf("%s %-12s %-40s %s\n", marker, cluster.Name, cluster.Proxy, state)
+		}
+		return nil
+	},
+}
+
+var clustersUseCmd = &cobra.Command{
+	Use:   "use <name>",
+	Short: "Switch the active cluster",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireTsh(); err != nil {
+			return err
+		}
+		return switchCluster(args[0])
+	},
+}
+
+var clustersLoginAll bool
+
+var clustersLoginCmd = &cobra.Command{
+	Use:   "login [name]",
+	Short: "Log in to a cluster, or to every cluster with --all",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireTsh(); err != nil {
+			return err
+		}
+
+		var targets []ClusterConfig
+		switch {
+		case clustersLoginAll:
+			targets = cfg.clusterProfiles()
+		case len(args) == 1:
+			cluster, err := cfg.findCluster(args[0])
+			if err != nil {
+				return err
+			}
+			targets = []ClusterConfig{cluster}
+		default:
+			targets = []ClusterConfig{cfg.activeCluster()}
+		}
+
+		for _, cluster := range targets {
+			if err := loginToCluster(cluster, cluster.AuthConnector); err != nil {
+				return err
+			}
+		}
+
+		// Logging in switches tsh's active profile, so switch back
+		if clustersLoginAll {
+			active := cfg.activeCluster()
+			return tshClient.Login(active.Proxy, active.AuthConnector)
+		}
+		return nil
+	},
+}
+
+var clustersAddCmd = &cobra.Command{
+	Use:   "add <name> <proxy> [--auth-connector name]",
+	Short: "Add a cluster profile to the config file",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := configPath()
+		if err != nil {
+			return err
+		}
+
+		unlock, err := acquireLock()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		fileConfig := &Config{}
+		if err := readConfigFile(path, fileConfig); err != nil {
+			return err
+		}
+
+		// The implicit default cluster becomes explicit once a second one is added
+		if len(fileConfig.Clusters) == 0 {
+			fileConfig.Clusters = append(fileConfig.Clusters, ClusterConfig{Name: defaultClusterName, Proxy: cfg.Proxy})
+		}
+		fileConfig.Clusters = append(fileConfig.Clusters, ClusterConfig{
+			Name:          args[0],
+			Proxy:         args[1],
+			AuthConnector: authConnectorFlag,
+		})
+
+		merged := defaultConfig()
+		if err := readConfigFile(path, merged); err != nil {
+			return err
+		}
+		merged.Clusters = fileConfig.Clusters
+		if err := merged.validate(); err != nil {
+			return err
+		}
+
+		if err := writeConfigFile(path, fileConfig); err != nil {
+			return err
+		}
+
+		fmt.Printf("✓ Added cluster %s (%s)\n", args[0], args[1])
+		return nil
+	},
+}
+
+var clustersRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a cluster profile from the config file",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := configPath()
+		if err != nil {
+			return err
+		}
+
+		unlock, err := acquireLock()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		fileConfig := &Config{}
+		if err := readConfigFile(path, fileConfig); err != nil {
+			return err
+		}
+
+		var kept []ClusterConfig
+		for _, cluster := range fileConfig.Clusters {
+			if cluster.Name != args[0] {
+				kept = append(kept, cluster)
+			}
+		}
+		if len(kept) == len(fileConfig.Clusters) {
+			return fmt.Errorf("unknown cluster %q", args[0])
+		}
+
+		fileConfig.Clusters = kept
+		if fileConfig.ActiveCluster == args[0] {
+			fileConfig.ActiveCluster = ""
+		}
+		if err := writeConfigFile(path, fileConfig); err != nil {
+			return err
+		}
+
+		fmt.Printf("✓ Removed cluster %s\n", args[0])
+		fmt.Println("Run 'scicom-helper update-nodes' to remove its hosts from your SSH config")
+		return nil
+	},
+}
+
+func init() {
+	clustersLoginCmd.Flags().BoolVar(&clustersLoginAll, "all", false, "log in to every configured cluster")
+
+	clustersCmd.AddCommand(clustersListCmd, clustersUseCmd, clustersLoginCmd, clustersAddCmd, clustersRemoveCmd)
+	rootCmd.AddCommand(clustersCmd)
+}
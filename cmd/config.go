@@ -0,0 +1,626 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the user's scicom-helper settings.
+// Values are resolved in order: defaults, config file, environment, flags.
+type Config struct {
+	// Proxy is the single cluster used when no Clusters are configured
+	Proxy         string          `yaml:"proxy,omitempty"`
+	AuthConnector string          `yaml:"auth_connector,omitempty"`
+	LoginPriority []string        `yaml:"login_priority,omitempty"`
+	SSHPort       int             `yaml:"ssh_port,omitempty"`
+	Editors       []string        `yaml:"editors,omitempty"`
+	Clusters      []ClusterConfig `yaml:"clusters,omitempty"`
+	ActiveCluster string          `yaml:"active_cluster,omitempty"`
+	// BackupRetention is the number of backups kept per file
+	BackupRetention int `yaml:"backup_retention,omitempty"`
+	// AliasTemplate names SSH host aliases from node labels, e.g.
+	// "{{.Labels.env}}-{{.Hostname}}"; empty means the node name
+	AliasTemplate string `yaml:"alias_template,omitempty"`
+	// IncludeLabels and ExcludeLabels select nodes by label (key=value or
+	// key!=value, values may use * and ?); IncludeNodes and ExcludeNodes
+	// select them by name glob. Nodes that don't pass get no SSH config entry.
+	IncludeLabels []string `yaml:"include_labels,omitempty"`
+	ExcludeLabels []string `yaml:"exclude_labels,omitempty"`
+	IncludeNodes  []string `yaml:"include_nodes,omitempty"`
+	ExcludeNodes  []string `yaml:"exclude_nodes,omitempty"`
+	// NodeLogins sets the login of nodes by name or glob, e.g. "db-*": postgres
+	NodeLogins map[string]string `yaml:"node_logins,omitempty"`
+	// LoginRules set the login of nodes by label, first match wins
+	LoginRules []LoginRule `yaml:"login_rules,omitempty"`
+	// SSHDirectives add directives such as LocalForward or ForwardAgent to
+	// the generated hosts; SSHPresets name reusable sets of directives
+	SSHDirectives []SSHDirectiveRule  `yaml:"ssh_directives,omitempty"`
+	SSHPresets    map[string][]string `yaml:"ssh_presets,omitempty"`
+	// PickerLabels are the label keys shown as columns in the node picker;
+	// empty picks the most common ones
+	PickerLabels []string `yaml:"picker_labels,omitempty"`
+	// PickerSort orders the node picker: "recent" or "name"
+	PickerSort string `yaml:"picker_sort,omitempty"`
+	// Favorites are pinned to the top of the node picker, managed with
+	// 'scicom-helper favorites'
+	Favorites []FavoriteNode `yaml:"favorites,omitempty"`
+}
+
+// defaultConfig returns the built-in settings for the Scicom Teleport cluster
+func defaultConfig() *Config {
+	return &Config{
+		Proxy:         "teleport-iam.aies.scicom.dev",
+		AuthConnector: "github-connector",
+		LoginPriority: []string{"ubuntu", "root"},
+		SSHPort:       3022,
+		Editors:       []string{"vscode", "cursor"},
+
+		BackupRetention: 10,
+		PickerSort:      "recent",
+	}
+}
+
+// cfg is the effective configuration, loaded before any command runs
+var cfg = defaultConfig()
+
+var (
+	configPathFlag    string
+	proxyFlag         string
+	authConnectorFlag string
+	clusterFlag       string
+)
+
+// configKey describes a setting that can be read and written by name
+type configKey struct {
+	name  string
+	usage string
+	get   func(c *Config) string
+	set   func(c *Config, value string) error
+}
+
+var configKeys = []configKey{
+	{
+		name:  "proxy",
+		usage: "Teleport proxy address",
+		get:   func(c *Config) string { return c.Proxy },
+		set: func(c *Config, value string) error {
+			c.Proxy = value
+			return nil
+		},
+	},
+	{
+		name:  "auth_connector",
+		usage: "auth connector used for SSO login",
+		get:   func(c *Config) string { return c.AuthConnector },
+		set: func(c *Config, value string) error {
+			c.AuthConnector = value
+			return nil
+		},
+	},
+	{
+		name:  "login_priority",
+		usage: "comma-separated logins preferred as the default, in order",
+		get:   func(c *Config) string { return strings.Join(c.LoginPriority, ",") },
+		set: func(c *Config, value string) error {
+			c.LoginPriority = splitList(value)
+			return nil
+		},
+	},
+	{
+		name:  "ssh_port",
+		usage: "SSH port of Teleport nodes",
+		get:   func(c *Config) string { return strconv.Itoa(c.SSHPort) },
+		set: func(c *Config, value string) error {
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid ssh_port %q: %v", value, err)
+			}
+			c.SSHPort = port
+			return nil
+		},
+	},
+	{
+		name:  "active_cluster",
+		usage: "name of the cluster profile used by default",
+		get:   func(c *Config) string { return c.ActiveCluster },
+		set: func(c *Config, value string) error {
+			c.ActiveCluster = value
+			return nil
+		},
+	},
+	{
+		name:  "editors",
+		usage: "comma-separated editors to configure (" + strings.Join(knownEditorNames(), ", ") + ")",
+		get:   func(c *Config) string { return strings.Join(c.Editors, ",") },
+		set: func(c *Config, value string) error {
+			c.Editors = splitList(value)
+			return nil
+		},
+	},
+	{
+		name:  "backup_retention",
+		usage: "number of backups kept for each file",
+		get:   func(c *Config) string { return strconv.Itoa(c.BackupRetention) },
+		set: func(c *Config, value string) error {
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid backup_retention %q: %v", value, err)
+			}
+			c.BackupRetention = count
+			return nil
+		},
+	},
+	{
+		name:  "alias_template",
+		usage: "Go template for SSH host aliases, e.g. {{.Labels.env}}-{{.Hostname}}",
+		get:   func(c *Config) string { return c.AliasTemplate },
+		set: func(c *Config, value string) error {
+			c.AliasTemplate = value
+			return nil
+		},
+	},
+	{
+		name:  "node_logins",
+		usage: "comma-separated node=login overrides, node may be a glob, e.g. db-*=postgres",
+		get:   func(c *Config) string { return formatNodeLogins(c.NodeLogins) },
+		set: func(c *Config, value string) error {
+			logins, err := parseNodeLogins(value)
+			if err != nil {
+				return err
+			}
+			c.NodeLogins = logins
+			return nil
+		},
+	},
+	{
+		name:  "picker_labels",
+		usage: "comma-separated label keys shown as columns in the node picker",
+		get:   func(c *Config) string { return strings.Join(c.PickerLabels, ",") },
+		set: func(c *Config, value string) error {
+			c.PickerLabels = splitList(value)
+			return nil
+		},
+	},
+	{
+		name:  "picker_sort",
+		usage: "order of the node picker (" + strings.Join(pickerSorts, ", ") + ")",
+		get:   func(c *Config) string { return c.PickerSort },
+		set: func(c *Config, value string) error {
+			c.PickerSort = value
+			return nil
+		},
+	},
+	{
+		name:  "include_labels",
+		usage: "comma-separated label selectors nodes must all match, e.g. env=prod,team=ml-*",
+		get:   func(c *Config) string { return strings.Join(c.IncludeLabels, ",") },
+		set: func(c *Config, value string) error {
+			c.IncludeLabels = splitList(value)
+			return nil
+		},
+	},
+	{
+		name:  "exclude_labels",
+		usage: "comma-separated label selectors that leave a node out, e.g. env=dev",
+		get:   func(c *Config) string { return strings.Join(c.ExcludeLabels, ",") },
+		set: func(c *Config, value string) error {
+			c.ExcludeLabels = splitList(value)
+			return nil
+		},
+	},
+	{
+		name:  "include_nodes",
+		usage: "comma-separated node name globs; only matching nodes are added",
+		get:   func(c *Config) string { return strings.Join(c.IncludeNodes, ",") },
+		set: func(c *Config, value string) error {
+			c.IncludeNodes = splitList(value)
+			return nil
+		},
+	},
+	{
+		name:  "exclude_nodes",
+		usage: "comma-separated node name globs that are left out",
+		get:   func(c *Config) string { return strings.Join(c.ExcludeNodes, ",") },
+		set: func(c *Config, value string) error {
+			c.ExcludeNodes = splitList(value)
+			return nil
+		},
+	},
+}
+
+// findConfigKey looks up a setting by name
+func findConfigKey(name string) (configKey, error) {
+	for _, key := range configKeys {
+		if key.name == name {
+			return key, nil
+		}
+	}
+	return configKey{}, fmt.Errorf("unknown config key %q", name)
+}
+
+// envName returns the environment variable that overrides a setting
+func (k configKey) envName() string {
+	return "SCICOM_HELPER_" + strings.ToUpper(k.name)
+}
+
+// splitList splits a comma-separated value, dropping empty entries
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// configDir returns the directory holding the scicom-helper config file
+func configDir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "scicom-helper"), nil
+	}
+
+	if runtime.GOOS == "windows" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "scicom-helper"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %v", err)
+	}
+	return filepath.Join(home, ".config", "scicom-helper"), nil
+}
+
+// configPath returns the config file location, honouring --config and SCICOM_HELPER_CONFIG
+func configPath() (string, error) {
+	if configPathFlag != "" {
+		return configPathFlag, nil
+	}
+	if path := os.Getenv("SCICOM_HELPER_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.yaml"), nil
+}
+
+// readConfigFile decodes the config file at path on top of base.
+// A missing file leaves base unchanged.
+func readConfigFile(path string, base *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	if err := yaml.Unmarshal(data, base); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// writeConfigFile saves c to path, creating the directory if needed
+func writeConfigFile(path string, c *Config) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+	return nil
+}
+
+// loadConfig resolves the effective configuration from defaults, the config
+// file, SCICOM_HELPER_* environment variables and command-line flags
+func loadConfig() (*Config, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+
+	c := defaultConfig()
+	if err := readConfigFile(path, c); err != nil {
+		return nil, err
+	}
+
+	for _, key := range configKeys {
+		if value, ok := os.LookupEnv(key.envName()); ok {
+			if err := key.set(c, value); err != nil {
+				return nil, fmt.Errorf("%s: %v", key.envName(), err)
+			}
+		}
+	}
+
+	// An explicit proxy, from --proxy or SCICOM_HELPER_PROXY, always means a
+	// single, ad-hoc cluster
+	proxy := proxyFlag
+	if proxy == "" {
+		proxy = os.Getenv("SCICOM_HELPER_PROXY")
+	}
+	if proxy != "" {
+		c.Proxy = proxy
+		c.Clusters = nil
+		c.ActiveCluster = ""
+	}
+	if clusterFlag != "" {
+		c.ActiveCluster = clusterFlag
+	}
+	if authConnectorFlag != "" {
+		c.AuthConnector = authConnectorFlag
+		for i := range c.Clusters {
+			c.Clusters[i].AuthConnector = authConnectorFlag
+		}
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseNodeLogins parses a comma-separated list of node=login pairs
+func parseNodeLogins(value string) (map[string]string, error) {
+	logins := map[string]string{}
+	for _, item := range splitList(value) {
+		node, login, ok := strings.Cut(item, "=")
+		node, login = strings.TrimSpace(node), strings.TrimSpace(login)
+		if !ok || node == "" || login == "" {
+			return nil, fmt.Errorf("invalid node_logins entry %q (expected node=login)", item)
+		}
+		logins[node] = login
+	}
+	return logins, nil
+}
+
+// formatNodeLogins renders node_logins as sorted node=login pairs
+func formatNodeLogins(logins map[string]string) string {
+	pairs := make([]string, 0, len(logins))
+	for node, login := range logins {
+		pairs = append(pairs, node+"="+login)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// validateProxy checks that a proxy is a plain host name without a port, as
+// it is written into Match host and ProxyCommand lines of the SSH config
+func validateProxy(proxy string) error {
+	if err := validateNodeName(proxy); err != nil {
+		return fmt.Errorf("invalid proxy %q: %v", proxy, err)
+	}
+	return nil
+}
+
+// validate checks that the configuration is usable
+func (c *Config) validate() error {
+	if c.Proxy == "" {
+		return fmt.Errorf("proxy must not be empty")
+	}
+	if err := validateProxy(c.Proxy); err != nil {
+		return err
+	}
+	if c.AuthConnector == "" {
+		return fmt.Errorf("auth_connector must not be empty")
+	}
+	if c.SSHPort <= 0 || c.SSHPort > 65535 {
+		return fmt.Errorf("ssh_port must be between 1 and 65535, got %d", c.SSHPort)
+	}
+	if c.BackupRetention < 1 {
+		return fmt.Errorf("backup_retention must be at least 1, got %d", c.BackupRetention)
+	}
+	if !slices.Contains(pickerSorts, c.PickerSort) {
+		return fmt.Errorf("picker_sort must be one of %s, got %q", strings.Join(pickerSorts, ", "), c.PickerSort)
+	}
+	if c.AliasTemplate != "" {
+		if _, err := parseAliasTemplate(c.AliasTemplate); err != nil {
+			return err
+		}
+	}
+	if _, err := newNodeFilter(c); err != nil {
+		return err
+	}
+	for node, login := range c.NodeLogins {
+		if err := validateLogin(login); err != nil {
+			return fmt.Errorf("node_logins %s: %v", node, err)
+		}
+	}
+	if err := c.validateSSHDirectives(); err != nil {
+		return err
+	}
+	for _, favorite := range c.Favorites {
+		if err := validateNodeName(favorite.Node); err != nil {
+			return fmt.Errorf("favorites: invalid node %q: %v", favorite.Node, err)
+		}
+	}
+	for i, rule := range c.LoginRules {
+		if err := validateLogin(rule.Login); err != nil {
+			return fmt.Errorf("login_rules[%d]: %v", i, err)
+		}
+		for _, text := range rule.Labels {
+			if _, err := parseLabelSelector(text); err != nil {
+				return fmt.Errorf("login_rules[%d]: %v", i, err)
+			}
+		}
+	}
+	for _, name := range c.Editors {
+		if _, ok := knownEditors[name]; !ok {
+			return fmt.Errorf("unknown editor %q (expected one of: %s)", name, strings.Join(knownEditorNames(), ", "))
+		}
+	}
+	return c.validateClusters()
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show and change scicom-helper settings",
+	Long: `Show and change scicom-helper settings.
+
+Settings are stored in ~/.config/scicom-helper/config.yaml (or --config).
+Each setting can be overridden with a SCICOM_HELPER_<KEY> environment variable.`,
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get [key]",
+	Short: "Print the effective value of a setting, or all settings",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 1 {
+			key, err := findConfigKey(args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Println(key.get(cfg))
+			return nil
+		}
+
+		for _, key := range configKeys {
+			fmt.Printf("%s = %s\n", key.name, key.get(cfg))
+		}
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Save a setting to the config file",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := findConfigKey(args[0])
+		if err != nil {
+			return err
+		}
+
+		path, err := configPath()
+		if err != nil {
+			return err
+		}
+
+		unlock, err := acquireLock()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		// Only the file contents are saved, never environment or flag overrides
+		fileConfig := &Config{}
+		if err := readConfigFile(path, fileConfig); err != nil {
+			return err
+		}
+		if err := key.set(fileConfig, args[1]); err != nil {
+			return err
+		}
+
+		// Validate the result as it will be loaded next time
+		merged := defaultConfig()
+		if err := readConfigFile(path, merged); err != nil {
+			return err
+		}
+		if err := key.set(merged, args[1]); err != nil {
+			return err
+		}
+		if err := merged.validate(); err != nil {
+			return err
+		}
+
+		if err := writeConfigFile(path, fileConfig); err != nil {
+			return err
+		}
+
+		fmt.Printf("✓ Set %s = %s in %s\n", key.name, key.get(fileConfig), path)
+		return nil
+	},
+}
+
+var configEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Open the config file in $EDITOR",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := configPath()
+		if err != nil {
+			return err
+		}
+
+		// Start from the defaults so every setting is visible
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err := writeConfigFile(path, defaultConfig()); err != nil {
+				return err
+			}
+		}
+
+		editor := os.Getenv("VISUAL")
+		if editor == "" {
+			editor = os.Getenv("EDITOR")
+		}
+		if editor == "" {
+			editor = "vi"
+			if runtime.GOOS == "windows" {
+				editor = "notepad"
+			}
+		}
+
+		// $EDITOR may contain arguments, e.g. "code --wait"
+		parts := strings.Fields(editor)
+		editCmd := exec.Command(parts[0], append(parts[1:], path)...)
+		editCmd.Stdin = os.Stdin
+		editCmd.Stdout = os.Stdout
+		editCmd.Stderr = os.Stderr
+		if err := editCmd.Run(); err != nil {
+			return fmt.Errorf("editor failed: %v", err)
+		}
+
+		edited := defaultConfig()
+		if err := readConfigFile(path, edited); err != nil {
+			return err
+		}
+		if err := edited.validate(); err != nil {
+			return fmt.Errorf("%s is invalid: %v", path, err)
+		}
+
+		fmt.Printf("✓ Saved %s\n", path)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configPathFlag, "config", "", "config file (default ~/.config/scicom-helper/config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&proxyFlag, "proxy", "", "Teleport proxy address (overrides config)")
+	rootCmd.PersistentFlags().StringVar(&authConnectorFlag, "auth-connector", "", "Teleport SSO auth connector (overrides config)")
+	rootCmd.PersistentFlags().StringVar(&clusterFlag, "cluster", "", "cluster profile to use (overrides active_cluster)")
+
+	var keys []string
+	for _, key := range configKeys {
+		keys = append(keys, fmt.Sprintf("  %-16s %s", key.name, key.usage))
+	}
+	sort.Strings(keys)
+	configCmd.Long += "\n\nAvailable keys:\n" + strings.Join(keys, "\n")
+
+	configCmd.AddCommand(configGetCmd, configSetCmd, configEditCmd)
+	rootCmd.AddCommand(configCmd)
+}
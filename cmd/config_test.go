@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateProxyAndClusters(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string
+	}{
+		{"default", func(c *Config) {}, ""},
+		{"proxy with space", func(c *Config) { c.Proxy = "teleport.example.com extra" }, "invalid proxy"},
+		{"proxy with quote", func(c *Config) { c.Proxy = `teleport"example.com` }, "invalid proxy"},
+		{"proxy with percent", func(c *Config) { c.Proxy = "teleport%h.example.com" }, "invalid proxy"},
+		{"proxy with port", func(c *Config) { c.Proxy = "teleport.example.com:443" }, "invalid proxy"},
+		{"cluster proxy", func(c *Config) {
+			c.Clusters = []ClusterConfig{{Name: "lab", Proxy: "lab.example.com *"}}
+		}, `cluster "lab": invalid proxy`},
+		{"cluster name", func(c *Config) {
+			c.Clusters = []ClusterConfig{{Name: "lab one", Proxy: "lab.example.com"}}
+		}, "invalid cluster name"},
+		{"valid cluster", func(c *Config) {
+			c.Clusters = []ClusterConfig{{Name: "lab-1", Proxy: "lab.example.com"}}
+		}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultConfig()
+			tt.change(c)
+			err := c.validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigSetRejectsBadProxy(t *testing.T) {
+	home := useTestEnv(t, &fakeTsh{})
+	path := filepath.Join(home, ".config", "scicom-helper", "config.yaml")
+
+	if err := configSetCmd.RunE(configSetCmd, []string{"proxy", "bad proxy"}); err == nil {
+		t.Fatal("config set accepted a proxy with a space")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("config file was written: %v", err)
+	}
+
+	if err := configSetCmd.RunE(configSetCmd, []string{"proxy", "teleport.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, path); !strings.Contains(got, "proxy: teleport.example.com") {
+		t.Errorf("proxy not saved:\n%s", got)
+	}
+}
+
+func TestLoadConfigProxyOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       string
+		flag      string
+		wantProxy string
+		clusters  int
+	}{
+		{name: "config file", wantProxy: "file.example.com", clusters: 2},
+		{name: "environment", env: "env.example.com", wantProxy: "env.example.com"},
+		{name: "flag", flag: "flag.example.com", wantProxy: "flag.example.com"},
+		{name: "flag over environment", env: "env.example.com", flag: "flag.example.com", wantProxy: "flag.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := useTestEnv(t, &fakeTsh{})
+			path := filepath.Join(home, ".config", "scicom-helper", "config.yaml")
+			if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				t.Fatal(err)
+			}
+			file := "proxy: file.example.com\nactive_cluster: lab\nclusters:\n  - name: lab\n    proxy: lab.example.com\n  - name: prod\n    proxy: prod.example.com\n"
+			if err := os.WriteFile(path, []byte(file), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			t.Setenv("SCICOM_HELPER_PROXY", tt.env)
+			if tt.env == "" {
+				os.Unsetenv("SCICOM_HELPER_PROXY")
+			}
+			proxyFlag = tt.flag
+			t.Cleanup(func() { proxyFlag = "" })
+
+			c, err := loadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Proxy != tt.wantProxy || len(c.Clusters) != tt.clusters {
+				t.Errorf("proxy %s with %d cluster(s), want %s with %d", c.Proxy, len(c.Clusters), tt.wantProxy, tt.clusters)
+			}
+			if tt.clusters == 0 && c.ActiveCluster != "" {
+				t.Errorf("active_cluster %q is kept for an ad-hoc proxy", c.ActiveCluster)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// applyOps rebuilds both sides of an edit script
+func applyOps(ops []diffOp) (string, string) {
+	var a, b strings.Builder
+	for _, op := range ops {
+		if op.kind != '+' {
+			a.WriteString(op.line)
+		}
+		if op.kind != '-' {
+			b.WriteString(op.line)
+		}
+	}
+	return a.String(), b.String()
+}
+
+// countEdits returns the number of inserted and deleted lines
+func countEdits(ops []diffOp) int {
+	edits := 0
+	for _, op := range ops {
+		if op.kind != ' ' {
+			edits++
+		}
+	}
+	return edits
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		edits int
+	}{
+		{"", "", 0},
+		{"a\n", "", 1},
+		{"", "a\nb\n", 2},
+		{"a\nb\nc\n", "a\nb\nc\n", 0},
+		{"a\nb\nc\n", "a\nx\nc\n", 2},
+		{"a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", 5},
+		{"x\ny\nz\n", "a\nb\n", 5},
+		{"a\nb\nc\nd\n", "b\nc\nd\ne\n", 2},
+		{"a\nb", "a\nb\n", 2},
+	}
+
+	for _, tt := range tests {
+		ops := diffLines(splitLines(tt.a), splitLines(tt.b))
+		a, b := applyOps(ops)
+		if a != tt.a || b != tt.b {
+			t.Errorf("diffLines(%q, %q) rebuilds %q and %q", tt.a, tt.b, a, b)
+		}
+		if got := countEdits(ops); got != tt.edits {
+			t.Errorf("diffLines(%q, %q) has %d edits, want %d", tt.a, tt.b, got, tt.edits)
+		}
+	}
+}
+
+func TestDiffLinesLarge(t *testing.T) {
+	// A first run on a large cluster: every other host is new
+	var a, b []string
+	for i := 0; i < 10000; i++ {
+		a = append(a, fmt.Sprintf("Host node-%d\n", i))
+		b = append(b, fmt.Sprintf("Host node-%d\n", i))
+		if i%2 == 0 {
+			b = append(b, fmt.Sprintf("    User login-%d\n", i))
+		}
+	}
+
+	allocs := testing.AllocsPerRun(1, func() {
+		ops := diffLines(a, b)
+		if got := countEdits(ops); got != 5000 {
+			t.Fatalf("got %d edits, want 5000", got)
+		}
+	})
+	// The edit script itself grows by doubling; the search must not add
+	// an allocation per step
+	if allocs > 100 {
+		t.Errorf("diffLines made %.0f allocations", allocs)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	got := unifiedDiff("old", "new", "a\nb\nc\n", "a\nx\nc\n")
+	want := "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"
+	if got != want {
+		t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, want)
+	}
+	if got := unifiedDiff("old", "new", "same\n", "same\n"); got != "" {
+		t.Errorf("unifiedDiff of identical texts = %q, want empty", got)
+	}
+}
@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SSHDirectiveRule adds SSH directives to generated hosts. Nodes (name globs,
+// any must match) and Labels (selectors, all must match) pick the hosts; a
+// rule with neither applies to every host.
+type SSHDirectiveRule struct {
+	Nodes      []string `yaml:"nodes,omitempty"`
+	Labels     []string `yaml:"labels,omitempty"`
+	Presets    []string `yaml:"presets,omitempty"`
+	Directives []string `yaml:"directives,omitempty"`
+}
+
+// builtinPresets are the directive sets ssh_directives can refer to by name;
+// ssh_presets can add to or replace them
+var builtinPresets = map[string][]string{
+	"jupyter":     {"LocalForward 8888 localhost:8888"},
+	"tensorboard": {"LocalForward 6006 localhost:6006"},
+	"agent":       {"ForwardAgent yes"},
+	"keepalive":   {"ServerAliveInterval 30", "ServerAliveCountMax 3"},
+}
+
+// managedKeywords are set by update-nodes itself; overriding them from
+// ssh_directives would break the connection through Teleport
+var managedKeywords = map[string]string{
+	"host":               "it starts a new block",
+	"match":              "it starts a new block",
+	"include":            "it is not allowed inside a host block",
+	"hostname":           "it is set from the node name",
+	"port":               "it is set from ssh_port",
+	"proxycommand":       "it is set to tsh proxy ssh",
+	"proxyjump":          "it would bypass tsh proxy ssh",
+	"user":               "use node_logins or login_rules",
+	"identityfile":       "it is set to the Teleport key",
+	"certificatefile":    "it is set to the Teleport certificate",
+	"userknownhostsfile": "it is set to Teleport's known_hosts",
+}
+
+// sshDirective is a single "Keyword value" line from ssh_directives
+type sshDirective struct {
+	keyword string
+	value   string
+}
+
+// key identifies the directive regardless of the keyword's case
+func (d sshDirective) key() string {
+	return strings.ToLower(d.keyword) + " " + d.value
+}
+
+// parseSSHDirective splits "Keyword value" (or "Keyword=value") and checks
+// that it is a single directive update-nodes may write
+func parseSSHDirective(text string) (sshDirective, error) {
+	if strings.ContainsAny(text, "\r\n") {
+		return sshDirective{}, fmt.Errorf("invalid SSH directive %q: must be a single line", text)
+	}
+	line := parseSSHLine(text+"\n", 0)
+	if line.keyword == "" || len(line.args) == 0 {
+		return sshDirective{}, fmt.Errorf("invalid SSH directive %q (expected \"Keyword value\")", text)
+	}
+	if reason, ok := managedKeywords[line.keyword]; ok {
+		return sshDirective{}, fmt.Errorf("SSH directive %q is not allowed: %s", text, reason)
+	}
+
+	trimmed := strings.TrimSpace(text)
+	end := strings.IndexAny(trimmed, " \t=")
+	value := strings.TrimLeft(trimmed[end:], " \t")
+	value = strings.TrimLeft(strings.TrimPrefix(value, "="), " \t")
+	return sshDirective{keyword: trimmed[:end], value: value}, nil
+}
+
+// sshPreset returns the directives of a preset, preferring ssh_presets
+func (c *Config) sshPreset(name string) ([]string, bool) {
+	if directives, ok := c.SSHPresets[name]; ok {
+		return directives, true
+	}
+	directives, ok := builtinPresets[name]
+	return directives, ok
+}
+
+// sshPresetNames returns the names of all presets
+func (c *Config) sshPresetNames() []string {
+	seen := map[string]bool{}
+	var names []string
+	for name := range builtinPresets {
+		seen[name] = true
+		names = append(names, name)
+	}
+	for name := range c.SSHPresets {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateSSHDirectives checks ssh_presets and ssh_directives
+func (c *Config) validateSSHDirectives() error {
+	for name, directives := range c.SSHPresets {
+		for _, text := range directives {
+			if _, err := parseSSHDirective(text); err != nil {
+				return fmt.Errorf("ssh_presets %s: %v", name, err)
+			}
+		}
+	}
+	for i, rule := range c.SSHDirectives {
+		for _, text := range rule.Labels {
+			if _, err := parseLabelSelector(text); err != nil {
+				return fmt.Errorf("ssh_directives[%d]: %v", i, err)
+			}
+		}
+		for _, name := range rule.Presets {
+			if _, ok := c.sshPreset(name); !ok {
+				return fmt.Errorf("ssh_directives[%d]: unknown preset %q (expected one of: %s)", i, name, strings.Join(c.sshPresetNames(), ", "))
+			}
+		}
+		for _, text := range rule.Directives {
+			if _, err := parseSSHDirective(text); err != nil {
+				return fmt.Errorf("ssh_directives[%d]: %v", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// global reports whether the rule applies to every host
+func (r SSHDirectiveRule) global() bool {
+	return len(r.Nodes) == 0 && len(r.Labels) == 0
+}
+
+// matches reports whether the rule applies to node
+func (r SSHDirectiveRule) matches(node tshNode) bool {
+	if len(r.Nodes) > 0 && !matchSSHPatternList(r.Nodes, node.Hostname) {
+		return false
+	}
+	for _, text := range r.Labels {
+		selector, err := parseLabelSelector(text)
+		if err != nil || !selector.matches(node.Labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// directives returns the rule's presets followed by its own directives
+func (r SSHDirectiveRule) directives(c *Config) []string {
+	var result []string
+	for _, name := range r.Presets {
+		preset, _ := c.sshPreset(name)
+		result = append(result, preset...)
+	}
+	return append(result, r.Directives...)
+}
+
+// extraDirectives collects the directives of the matching rules in order,
+// dropping repeats. With node nil it collects the global rules, otherwise
+// the rules that name nodes or labels and match node, leaving out those the
+// global rules already set: ssh would apply them twice, and a LocalForward
+// would fail to bind its port the second time.
+func extraDirectives(c *Config, node *tshNode) []sshDirective {
+	seen := map[string]bool{}
+	if node != nil {
+		for _, directive := range extraDirectives(c, nil) {
+			seen[directive.key()] = true
+		}
+	}
+	var result []sshDirective
+	for _, rule := range c.SSHDirectives {
+		if node == nil && !rule.global() || node != nil && (rule.global() || !rule.matches(*node)) {
+			continue
+		}
+		for _, text := range rule.directives(c) {
+			directive, err := parseSSHDirective(text)
+			if err != nil {
+				continue
+			}
+			if !seen[directive.key()] {
+				seen[directive.key()] = true
+				result = append(result, directive)
+			}
+		}
+	}
+	return result
+}
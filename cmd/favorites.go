@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// FavoriteNode is a node pinned to the top of the node picker
+type FavoriteNode struct {
+	Cluster string `yaml:"cluster"`
+	Node    string `yaml:"node"`
+}
+
+// favoriteNodes returns the pinned nodes of cluster, in the order they were added
+func (c *Config) favoriteNodes(cluster string) []string {
+	var nodes []string
+	for _, favorite := range c.Favorites {
+		if favorite.Cluster == cluster {
+			nodes = append(nodes, favorite.Node)
+		}
+	}
+	return nodes
+}
+
+// isFavorite reports whether node of cluster is pinned
+func (c *Config) isFavorite(cluster, node string) bool {
+	for _, favorite := range c.Favorites {
+		if favorite.Cluster == cluster && favorite.Node == node {
+			return true
+		}
+	}
+	return false
+}
+
+// updateFavorites applies change to the favorites in the config file
+func updateFavorites(change func(c *Config) error) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := acquireLock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	fileConfig := &Config{}
+	if err := readConfigFile(path, fileConfig); err != nil {
+		return err
+	}
+	if err := change(fileConfig); err != nil {
+		return err
+	}
+
+	merged := defaultConfig()
+	if err := readConfigFile(path, merged); err != nil {
+		return err
+	}
+	merged.Favorites = fileConfig.Favorites
+	if err := merged.validate(); err != nil {
+		return err
+	}
+	if err := writeConfigFile(path, fileConfig); err != nil {
+		return err
+	}
+	cfg.Favorites = fileConfig.Favorites
+	return nil
+}
+
+var favoritesCmd = &cobra.Command{
+	Use:   "favorites",
+	Short: "Pin nodes to the top of the node picker",
+	Long: `Pin nodes to the top of the node picker.
+
+Favorites belong to the active cluster (see --cluster) and are saved in the
+config file.`,
+}
+
+var favoritesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the pinned nodes of the active cluster",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cluster := cfg.activeCluster().Name
+		nodes := cfg.favoriteNodes(cluster)
+		if len(nodes) == 0 {
+			fmt.Printf("No favorites in %s; add one with 'scicom-helper favorites add <node>'\n", cluster)
+			return nil
+		}
+		for _, node := range nodes {
+			fmt.Println(node)
+		}
+		return nil
+	},
+}
+
+var favoritesAddCmd = &cobra.Command{
+	Use:   "add <node>...",
+	Short: "Pin nodes of the active cluster",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cluster := cfg.activeCluster()
+		for _, node := range args {
+			if err := validateNodeName(node); err != nil {
+				return fmt.Errorf("invalid node %q: %v", node, err)
+			}
+		}
+
+		// Catch typos when the node list is available
+		if tshClient.Installed() {
+			if nodes, err := tshClient.Ls(cluster.Proxy); err == nil {
+				known := map[string]bool{}
+				for _, node := range nodes {
+					known[node.Hostname] = true
+				}
+				for _, node := range args {
+					if !known[node] {
+						fmt.Printf("Warning: %s is not one of your nodes in %s\n", node, cluster.Name)
+					}
+				}
+			}
+		}
+
+		var added []string
+		err := updateFavorites(func(c *Config) error {
+			for _, node := range args {
+				if !c.isFavorite(cluster.Name, node) {
+					c.Favorites = append(c.Favorites, FavoriteNode{Cluster: cluster.Name, Node: node})
+					added = append(added, node)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, node := range added {
+			fmt.Printf("✓ Pinned %s in %s\n", node, cluster.Name)
+		}
+		if len(added) == 0 {
+			fmt.Println("Already pinned")
+		}
+		return nil
+	},
+}
+
+var favoritesRemoveCmd = &cobra.Command{
+	Use:   "remove <node>...",
+	Short: "Unpin nodes of the active cluster",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cluster := cfg.activeCluster().Name
+		for _, node := range args {
+			if !cfg.isFavorite(cluster, node) {
+				return fmt.Errorf("%s is not a favorite in %s", node, cluster)
+			}
+		}
+
+		err := updateFavorites(func(c *Config) error {
+			remove := map[string]bool{}
+			for _, node := range args {
+				remove[node] = true
+			}
+			var kept []FavoriteNode
+			for _, favorite := range c.Favorites {
+				if favorite.Cluster != cluster || !remove[favorite.Node] {
+					kept = append(kept, favorite)
+				}
+			}
+			c.Favorites = kept
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, node := range args {
+			fmt.Printf("✓ Unpinned %s in %s\n", node, cluster)
+		}
+		return nil
+	},
+}
+
+func init() {
+	favoritesCmd.AddCommand(favoritesListCmd, favoritesAddCmd, favoritesRemoveCmd)
+	rootCmd.AddCommand(favoritesCmd)
+}
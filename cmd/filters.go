@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// labelSelector matches nodes whose label key has a value matching a glob
+// pattern ("env=prod", "team=ml-*"), or differs from it ("env!=dev")
+type labelSelector struct {
+	key     string
+	pattern string
+	negate  bool
+}
+
+// parseLabelSelector parses a key=value or key!=value selector
+func parseLabelSelector(text string) (labelSelector, error) {
+	if key, pattern, ok := strings.Cut(text, "!="); ok {
+		if strings.TrimSpace(key) == "" {
+			return labelSelector{}, fmt.Errorf("invalid label selector %q: missing label name", text)
+		}
+		return labelSelector{key: strings.TrimSpace(key), pattern: strings.TrimSpace(pattern), negate: true}, nil
+	}
+	key, pattern, ok := strings.Cut(text, "=")
+	if !ok || strings.TrimSpace(key) == "" {
+		return labelSelector{}, fmt.Errorf("invalid label selector %q (expected key=value or key!=value)", text)
+	}
+	return labelSelector{key: strings.TrimSpace(key), pattern: strings.TrimSpace(pattern)}, nil
+}
+
+// matches reports whether the node's labels satisfy the selector
+func (s labelSelector) matches(labels map[string]string) bool {
+	value, ok := labels[s.key]
+	matched := ok && matchSSHPattern(s.pattern, value)
+	return matched != s.negate
+}
+
+// nodeFilter decides which nodes get SSH config entries
+type nodeFilter struct {
+	includeLabels []labelSelector // all must match
+	excludeLabels []labelSelector // any excludes the node
+	includeNodes  []string        // one must match, if set
+	excludeNodes  []string        // any excludes the node
+}
+
+// newNodeFilter builds the filter from the configuration
+func newNodeFilter(c *Config) (*nodeFilter, error) {
+	filter := &nodeFilter{includeNodes: c.IncludeNodes, excludeNodes: c.ExcludeNodes}
+	for _, text := range c.IncludeLabels {
+		selector, err := parseLabelSelector(text)
+		if err != nil {
+			return nil, err
+		}
+		filter.includeLabels = append(filter.includeLabels, selector)
+	}
+	for _, text := range c.ExcludeLabels {
+		selector, err := parseLabelSelector(text)
+		if err != nil {
+			return nil, err
+		}
+		filter.excludeLabels = append(filter.excludeLabels, selector)
+	}
+	return filter, nil
+}
+
+// active reports whether the filter can exclude anything
+func (f *nodeFilter) active() bool {
+	return len(f.includeLabels)+len(f.excludeLabels)+len(f.includeNodes)+len(f.excludeNodes) > 0
+}
+
+// keep reports whether node passes the filter
+func (f *nodeFilter) keep(node tshNode) bool {
+	for _, selector := range f.includeLabels {
+		if !selector.matches(node.Labels) {
+			return false
+		}
+	}
+	for _, selector := range f.excludeLabels {
+		if selector.matches(node.Labels) {
+			return false
+		}
+	}
+	if len(f.includeNodes) > 0 && !matchSSHPatternList(f.includeNodes, node.Hostname) {
+		return false
+	}
+	for _, pattern := range f.excludeNodes {
+		if matchSSHPattern(pattern, node.Hostname) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterNodes applies the configured filters to the nodes of a cluster and
+// reports how many were left out
+func filterNodes(cluster string, nodes []tshNode) ([]tshNode, error) {
+	filter, err := newNodeFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !filter.active() {
+		return nodes, nil
+	}
+
+	hasLabels := false
+	var kept []tshNode
+	for _, node := range nodes {
+		hasLabels = hasLabels || len(node.Labels) > 0
+		if filter.keep(node) {
+			kept = append(kept, node)
+		}
+	}
+
+	if !hasLabels && len(filter.includeLabels)+len(filter.excludeLabels) > 0 {
+		fmt.Printf("Warning: tsh returned no labels for %s; label filters need a tsh version with 'tsh ls --format=json'\n", cluster)
+	}
+	if skipped := len(nodes) - len(kept); skipped > 0 {
+		fmt.Printf("Filtered out %d of %d node(s) in %s (include/exclude settings)\n", skipped, len(nodes), cluster)
+	}
+	return kept, nil
+}
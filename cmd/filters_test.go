@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    labelSelector
+		wantErr bool
+	}{
+		{text: "env=prod", want: labelSelector{key: "env", pattern: "prod"}},
+		{text: "env!=dev", want: labelSelector{key: "env", pattern: "dev", negate: true}},
+		{text: " team = ml-* ", want: labelSelector{key: "team", pattern: "ml-*"}},
+		{text: "env=", want: labelSelector{key: "env", pattern: ""}},
+		{text: "url=a=b", want: labelSelector{key: "url", pattern: "a=b"}},
+		{text: "env", wantErr: true},
+		{text: "=prod", wantErr: true},
+		{text: "!=prod", wantErr: true},
+		{text: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseLabelSelector(tt.text)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseLabelSelector(%q) = %+v, %v, want %+v (error %v)", tt.text, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNodeFilterKeep(t *testing.T) {
+	nodes := []tshNode{
+		{Hostname: "web-1", Labels: map[string]string{"env": "prod", "team": "web"}},
+		{Hostname: "web-2", Labels: map[string]string{"env": "dev", "team": "web"}},
+		{Hostname: "gpu-1", Labels: map[string]string{"env": "prod", "team": "ml-research"}},
+		{Hostname: "gpu-2", Labels: map[string]string{"team": "ml-infra"}},
+		{Hostname: "db-1"},
+	}
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"no filters", Config{}, "web-1,web-2,gpu-1,gpu-2,db-1"},
+		{"include label", Config{IncludeLabels: []string{"env=prod"}}, "web-1,gpu-1"},
+		{"include labels must all match", Config{IncludeLabels: []string{"env=prod", "team=ml-*"}}, "gpu-1"},
+		{"not equal includes nodes without the label", Config{IncludeLabels: []string{"env!=dev"}}, "web-1,gpu-1,gpu-2,db-1"},
+		{"any exclude label", Config{ExcludeLabels: []string{"env=dev", "team=ml-infra"}}, "web-1,gpu-1,db-1"},
+		{"include nodes", Config{IncludeNodes: []string{"web-*", "db-1"}}, "web-1,web-2,db-1"},
+		{"exclude nodes", Config{ExcludeNodes: []string{"gpu-*"}}, "web-1,web-2,db-1"},
+		{"combined", Config{IncludeLabels: []string{"team=*"}, ExcludeNodes: []string{"*-2"}}, "web-1,gpu-1"},
+	}
+	for _, tt := range tests {
+		filter, err := newNodeFilter(&tt.cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var kept []string
+		for _, node := range nodes {
+			if filter.keep(node) {
+				kept = append(kept, node.Hostname)
+			}
+		}
+		if got := strings.Join(kept, ","); got != tt.want {
+			t.Errorf("%s: kept %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFilterNodesReports(t *testing.T) {
+	useTestEnv(t, &fakeTsh{})
+	cfg.ExcludeLabels = []string{"env=dev"}
+
+	var kept []tshNode
+	output := captureOutput(t, func() {
+		var err error
+		kept, err = filterNodes("prod", []tshNode{{Hostname: "a"}, {Hostname: "b"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if len(kept) != 2 {
+		t.Errorf("kept %d node(s), want 2", len(kept))
+	}
+	if !strings.Contains(output, "Warning: tsh returned no labels for prod") {
+		t.Errorf("missing label warning:\n%s", output)
+	}
+
+	cfg.IncludeNodes = []string{"a"}
+	output = captureOutput(t, func() {
+		kept, _ = filterNodes("prod", []tshNode{{Hostname: "a", Labels: map[string]string{"env": "prod"}}, {Hostname: "b"}})
+	})
+	if len(kept) != 1 || !strings.Contains(output, "Filtered out 1 of 2 node(s) in prod") {
+		t.Errorf("kept %v, output:\n%s", kept, output)
+	}
+
+	cfg.IncludeLabels = []string{"env"}
+	if _, err := filterNodes("prod", nil); err == nil {
+		t.Error("filterNodes() with an invalid selector succeeded")
+	}
+}
+
+// filterFlagsCommand returns a command with the update-nodes filter flags
+// parsed from args
+func filterFlagsCommand(t *testing.T, args ...string) *cobra.Command {
+	t.Helper()
+	cmd := &cobra.Command{}
+	cmd.Flags().StringSliceVar(&updateNodesIncludeLabel, "include-label", nil, "")
+	cmd.Flags().StringSliceVar(&updateNodesExcludeLabel, "exclude-label", nil, "")
+	cmd.Flags().StringSliceVar(&updateNodesIncludeNode, "include-node", nil, "")
+	cmd.Flags().StringSliceVar(&updateNodesExcludeNode, "exclude-node", nil, "")
+	t.Cleanup(func() {
+		updateNodesIncludeLabel, updateNodesExcludeLabel = nil, nil
+		updateNodesIncludeNode, updateNodesExcludeNode = nil, nil
+	})
+	if err := cmd.Flags().Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func TestApplyNodeFilterFlags(t *testing.T) {
+	useTestEnv(t, &fakeTsh{})
+	cfg.IncludeLabels = []string{"env=dev"}
+	cfg.ExcludeNodes = []string{"db-*"}
+
+	cmd := filterFlagsCommand(t, "--include-label", "env=prod", "--include-label", "team=ml-*,gpu!=none", "--exclude-node", "gpu-*")
+	if err := applyNodeFilterFlags(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(cfg.IncludeLabels, " "); got != "env=prod team=ml-* gpu!=none" {
+		t.Errorf("include_labels = %s, want the three flag values", got)
+	}
+	if got := strings.Join(cfg.ExcludeNodes, " "); got != "gpu-*" {
+		t.Errorf("exclude_nodes = %s, want gpu-*", got)
+	}
+	if cfg.ExcludeLabels != nil || cfg.IncludeNodes != nil {
+		t.Errorf("filters without flags changed: %v %v", cfg.ExcludeLabels, cfg.IncludeNodes)
+	}
+
+	cmd = filterFlagsCommand(t, "--exclude-label", "env")
+	if err := applyNodeFilterFlags(cmd); err == nil {
+		t.Error("applyNodeFilterFlags() with an invalid selector succeeded")
+	}
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// maxHistoryEntries is the number of connections kept in the history
+	maxHistoryEntries = 500
+	// maxRecentNodes is the number of nodes in the picker's recent section
+	maxRecentNodes = 5
+)
+
+// connectionHistory is the list of sessions opened with scicom-helper, oldest first
+type connectionHistory struct {
+	Entries []historyEntry `json:"entries"`
+}
+
+// historyEntry is one session opened with scicom-helper ssh or the menu
+type historyEntry struct {
+	Cluster  string    `json:"cluster"`
+	Node     string    `json:"node"`
+	Login    string    `json:"login"`
+	Time     time.Time `json:"time"`
+	ExitCode int       `json:"exit_code"`
+	// Connected is false if the session could not be opened
+	Connected bool `json:"connected"`
+}
+
+// historyFile is the connection history in the state directory
+const historyFile = "history.json"
+
+// loadHistory reads the connection history; a missing file is empty
+func loadHistory() (*connectionHistory, error) {
+	history := &connectionHistory{}
+	if err := loadJSONState(historyFile, history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// recordConnection appends a session to the history, dropping the oldest
+// entries beyond maxHistoryEntries
+func recordConnection(entry historyEntry) error {
+	unlock, err := acquireLock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	history, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	history.Entries = append(history.Entries, entry)
+	if len(history.Entries) > maxHistoryEntries {
+		history.Entries = history.Entries[len(history.Entries)-maxHistoryEntries:]
+	}
+
+	return saveJSONState(historyFile, history)
+}
+
+// lastLogins returns the login of the latest connected session on each node
+func (h *connectionHistory) lastLogins() *lastLogins {
+	logins := &lastLogins{Clusters: map[string]map[string]lastLogin{}}
+	for _, entry := range h.Entries {
+		if !entry.Connected {
+			continue
+		}
+		if logins.Clusters[entry.Cluster] == nil {
+			logins.Clusters[entry.Cluster] = map[string]lastLogin{}
+		}
+		logins.Clusters[entry.Cluster][entry.Node] = lastLogin{Login: entry.Login, Time: entry.Time}
+	}
+	return logins
+}
+
+// lastUsed returns when each node of cluster was last connected to
+func (h *connectionHistory) lastUsed(cluster string) map[string]time.Time {
+	used := map[string]time.Time{}
+	for _, entry := range h.Entries {
+		if entry.Cluster == cluster && entry.Time.After(used[entry.Node]) {
+			used[entry.Node] = entry.Time
+		}
+	}
+	return used
+}
+
+// recentNodes returns up to limit nodes of cluster, most recently used first
+func (h *connectionHistory) recentNodes(cluster string, limit int) []string {
+	used := h.lastUsed(cluster)
+	nodes := make([]string, 0, len(used))
+	for node := range used {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if !used[nodes[i]].Equal(used[nodes[j]]) {
+			return used[nodes[i]].After(used[nodes[j]])
+		}
+		return nodes[i] < nodes[j]
+	})
+	if len(nodes) > limit {
+		nodes = nodes[:limit]
+	}
+	return nodes
+}
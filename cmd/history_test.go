@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadLastLogins(t *testing.T) {
+	useTestEnv(t, &fakeTsh{})
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []historyEntry{
+		{Cluster: "prod", Node: "web-1", Login: "ubuntu", Time: start, Connected: true},
+		{Cluster: "prod", Node: "web-1", Login: "root", Time: start.Add(time.Minute), ExitCode: 255},
+		{Cluster: "prod", Node: "web-2", Login: "deploy", Time: start.Add(2 * time.Minute), ExitCode: 1, Connected: true},
+	}
+	for _, entry := range entries {
+		if err := recordConnection(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	last, err := loadLastLogins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		node string
+		want string
+	}{
+		{"web-1", "ubuntu"},
+		{"web-2", "deploy"},
+	}
+	for _, tt := range tests {
+		if got, ok := last.lookup("prod", tt.node); !ok || got != tt.want {
+			t.Errorf("lookup(%s) = %q, %v, want %q", tt.node, got, ok, tt.want)
+		}
+	}
+	if got, ok := last.lookup("prod", "web-3"); ok {
+		t.Errorf("lookup(web-3) = %q, want none", got)
+	}
+}
+
+func TestConnectToNodeRecordsConnected(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+	tests := []struct {
+		name      string
+		login     string
+		command   []string
+		err       error
+		allowed   bool
+		connected bool
+	}{
+		{"clean exit", "ubuntu", []string{"true"}, nil, false, true},
+		{"interrupted shell", "ubuntu", nil, exitStatus(130), false, true},
+		{"remote command failed after probe", "ubuntu", []string{"false"}, exitStatus(1), true, true},
+		{"remote command failed without probe", "ubuntu", []string{"false"}, exitStatus(1), false, false},
+		{"connection failed after probe", "ubuntu", []string{"true"}, exitStatus(255), true, false},
+		{"access denied", "root", []string{"true"}, nil, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestEnv(t, &fakeTsh{
+				allowed: map[string][]string{"web-1": {"ubuntu"}},
+				sshErr:  tt.err,
+			})
+			captureOutput(t, func() {
+				connectToNode("web-1", tt.login, tt.command, tt.allowed)
+			})
+
+			history, err := loadHistory()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history.Entries) != 1 {
+				t.Fatalf("got %d history entries, want 1", len(history.Entries))
+			}
+			if got := history.Entries[0].Connected; got != tt.connected {
+				t.Errorf("Connected = %v, want %v", got, tt.connected)
+			}
+		})
+	}
+}
+
+func TestDeniedLoginDoesNotBecomeDefault(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+	useTestEnv(t, &fakeTsh{allowed: map[string][]string{"web-1": {"ubuntu"}}})
+
+	var stderr bytes.Buffer
+	err := tshClient.SSH(context.Background(), sshSession{Target: "root@web-1", Command: []string{"true"}, Stderr: &stderr})
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 || !strings.Contains(stderr.String(), "access denied") {
+		t.Fatalf("fake denial = %v, stderr %q, want exit status 1 and access denied", err, stderr.String())
+	}
+
+	captureOutput(t, func() {
+		connectToNode("web-1", "ubuntu", []string{"true"}, false)
+		connectToNode("web-1", "root", []string{"true"}, false)
+	})
+	if got := configuredNodeLogin("web-1"); got != "ubuntu" {
+		t.Errorf("configuredNodeLogin() after a denied login = %q, want ubuntu", got)
+	}
+}
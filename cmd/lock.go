@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// lockTimeout is how long to wait for another scicom-helper run to finish
+	lockTimeout = 30 * time.Second
+	lockPoll    = 200 * time.Millisecond
+)
+
+var (
+	// lockFile is the held lock, shared by nested acquireLock calls
+	lockFile  *os.File
+	lockDepth int
+)
+
+// acquireLock takes the advisory lock that serialises every scicom-helper
+// process modifying user files. It waits up to lockTimeout for another
+// process to finish and is re-entrant within a process. The returned
+// function releases the lock.
+func acquireLock() (func(), error) {
+	if lockDepth > 0 {
+		lockDepth++
+		return releaseLock, nil
+	}
+
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "scicom-helper.lock")
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	waiting := false
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+		if locked {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("another scicom-helper process%s is still modifying your files; try again when it has finished (lock file: %s)", lockOwner(path), path)
+		}
+		if !waiting {
+			fmt.Printf("Waiting for another scicom-helper process%s to finish...\n", lockOwner(path))
+			waiting = true
+		}
+		time.Sleep(lockPoll)
+	}
+
+	// Record our pid to help whoever has to wait for us
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	lockFile = f
+	lockDepth = 1
+	return releaseLock, nil
+}
+
+// releaseLock undoes one acquireLock call
+func releaseLock() {
+	lockDepth--
+	if lockDepth > 0 || lockFile == nil {
+		return
+	}
+
+	unlockFile(lockFile)
+	lockFile.Close()
+	lockFile = nil
+}
+
+// lockOwner describes the process recorded in the lock file, if any
+func lockOwner(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	pid := strings.TrimSpace(string(data))
+	if pid == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (pid %s)", pid)
+}
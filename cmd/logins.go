@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LoginRule sets the login of nodes whose labels match all of Labels
+type LoginRule struct {
+	Labels []string `yaml:"labels"`
+	Login  string   `yaml:"login"`
+}
+
+// Where a node's login came from, in order of precedence
+const (
+	loginSourceOverride = "node_logins"
+	loginSourceLast     = "last login"
+	loginSourceRule     = "login_rules"
+	loginSourcePriority = "login_priority"
+)
+
+// lastLogins is the login that last worked for each node, keyed by cluster
+// name and then node hostname
+type lastLogins struct {
+	Clusters map[string]map[string]lastLogin `json:"clusters"`
+}
+
+// lastLogin is a login that opened a session on a node
+type lastLogin struct {
+	Login string    `json:"login"`
+	Time  time.Time `json:"time"`
+}
+
+// loadLastLogins returns the last login that worked on each node, from the
+// connection history
+func loadLastLogins() (*lastLogins, error) {
+	history, err := loadHistory()
+	if err != nil {
+		return nil, err
+	}
+	return history.lastLogins(), nil
+}
+
+// lookup returns the last login that worked for node, if any
+func (l *lastLogins) lookup(cluster, node string) (string, bool) {
+	last, ok := l.Clusters[cluster][node]
+	return last.Login, ok && last.Login != ""
+}
+
+// validateLogin checks that a login can be written as an SSH User directive
+func validateLogin(login string) error {
+	if login == "" {
+		return fmt.Errorf("empty login")
+	}
+	if strings.HasPrefix(login, "-") {
+		return fmt.Errorf("login %q starts with '-'", login)
+	}
+	for _, r := range login {
+		if r <= ' ' || r == 0x7f || strings.ContainsRune(`"'#\`, r) {
+			return fmt.Errorf("login %q contains %q", login, r)
+		}
+	}
+	return nil
+}
+
+// overrideLogin returns the login node_logins sets for node. An exact name
+// wins over globs; among globs the longest (most specific) pattern wins.
+func overrideLogin(overrides map[string]string, node string) (string, bool) {
+	if login, ok := overrides[node]; ok {
+		return login, true
+	}
+
+	patterns := make([]string, 0, len(overrides))
+	for pattern := range overrides {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+	for _, pattern := range patterns {
+		if matchSSHPattern(pattern, node) {
+			return overrides[pattern], true
+		}
+	}
+	return "", false
+}
+
+// ruleLogin returns the login of the first login rule matching the labels
+func ruleLogin(rules []LoginRule, labels map[string]string) (string, bool) {
+	for _, rule := range rules {
+		matched := true
+		for _, text := range rule.Labels {
+			selector, err := parseLabelSelector(text)
+			if err != nil || !selector.matches(labels) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return rule.Login, true
+		}
+	}
+	return "", false
+}
+
+// resolveNodeLogin picks the login for node: a node_logins override, the last
+// login that worked, a login_rules match, and finally fallback (the best
+// login from login_priority). It also returns where the login came from.
+func resolveNodeLogin(cluster string, node tshNode, last *lastLogins, fallback string) (string, string) {
+	if login, ok := overrideLogin(cfg.NodeLogins, node.Hostname); ok {
+		return login, loginSourceOverride
+	}
+	if last != nil {
+		if login, ok := last.lookup(cluster, node.Hostname); ok {
+			return login, loginSourceLast
+		}
+	}
+	if login, ok := ruleLogin(cfg.LoginRules, node.Labels); ok {
+		return login, loginSourceRule
+	}
+	return fallback, loginSourcePriority
+}
+
+// assignNodeLogins sets the login of every alias whose login differs from
+// the default login of its cluster
+func assignNodeLogins(states []clusterState, aliases map[string][]nodeAlias) {
+	last, err := loadLastLogins()
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		last = nil
+	}
+
+	for _, state := range states {
+		nodes := map[string]tshNode{}
+		for _, node := range state.nodes {
+			nodes[node.Hostname] = node
+		}
+
+		counts := map[string]int{}
+		clusterAliases := aliases[state.cluster.Name]
+		for i, alias := range clusterAliases {
+			login, source := resolveNodeLogin(state.cluster.Name, nodes[alias.Hostname], last, state.defaultUser)
+			if login == state.defaultUser {
+				continue
+			}
+			if err := validateLogin(login); err != nil {
+				fmt.Printf("Warning: ignoring %s login for %s: %v\n", source, alias.Hostname, err)
+				continue
+			}
+			clusterAliases[i].User = login
+			counts[source]++
+		}
+
+		for _, source := range []string{loginSourceOverride, loginSourceLast, loginSourceRule} {
+			if counts[source] > 0 {
+				fmt.Printf("Using %s for the login of %d node(s) in %s\n", source, counts[source], state.cluster.Name)
+			}
+		}
+	}
+}
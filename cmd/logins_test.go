@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOverrideLogin(t *testing.T) {
+	overrides := map[string]string{
+		"db-*":      "postgres",
+		"db-main-*": "dba",
+		"db-main-1": "root",
+		"gpu-?":     "ml",
+	}
+	tests := []struct {
+		node   string
+		want   string
+		wantOK bool
+	}{
+		{"db-main-1", "root", true},
+		{"db-main-2", "dba", true},
+		{"db-replica", "postgres", true},
+		{"gpu-1", "ml", true},
+		{"gpu-10", "", false},
+		{"web-1", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := overrideLogin(overrides, tt.node)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("overrideLogin(%s) = %q, %v, want %q, %v", tt.node, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRuleLogin(t *testing.T) {
+	rules := []LoginRule{
+		{Labels: []string{"os=amazon*", "env!=dev"}, Login: "ec2-user"},
+		{Labels: []string{"os=amazon*"}, Login: "ec2-dev"},
+		{Labels: []string{"role=db"}, Login: "postgres"},
+	}
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+		wantOK bool
+	}{
+		{"all selectors match", map[string]string{"os": "amazon-linux", "env": "prod"}, "ec2-user", true},
+		{"first rule fails on one selector", map[string]string{"os": "amazon-linux", "env": "dev"}, "ec2-dev", true},
+		{"first matching rule wins", map[string]string{"os": "amazon2", "role": "db"}, "ec2-user", true},
+		{"later rule", map[string]string{"role": "db"}, "postgres", true},
+		{"no labels", nil, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := ruleLogin(rules, tt.labels)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: ruleLogin() = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	// A rule with an invalid selector never matches
+	if got, ok := ruleLogin([]LoginRule{{Labels: []string{"os"}, Login: "x"}}, map[string]string{"os": "x"}); ok {
+		t.Errorf("ruleLogin() with an invalid selector = %q, want no match", got)
+	}
+}
+
+func TestResolveNodeLoginOrder(t *testing.T) {
+	node := tshNode{Hostname: "db-1", Labels: map[string]string{"role": "db"}}
+	last := &lastLogins{Clusters: map[string]map[string]lastLogin{
+		"prod": {"db-1": {Login: "admin", Time: time.Now()}},
+	}}
+
+	tests := []struct {
+		name       string
+		overrides  map[string]string
+		rules      []LoginRule
+		last       *lastLogins
+		want       string
+		wantSource string
+	}{
+		{
+			name:       "node_logins wins over everything",
+			overrides:  map[string]string{"db-*": "postgres"},
+			rules:      []LoginRule{{Labels: []string{"role=db"}, Login: "dba"}},
+			last:       last,
+			want:       "postgres",
+			wantSource: loginSourceOverride,
+		},
+		{
+			name:       "last login wins over login_rules",
+			rules:      []LoginRule{{Labels: []string{"role=db"}, Login: "dba"}},
+			last:       last,
+			want:       "admin",
+			wantSource: loginSourceLast,
+		},
+		{
+			name:       "login_rules without a last login",
+			rules:      []LoginRule{{Labels: []string{"role=db"}, Login: "dba"}},
+			want:       "dba",
+			wantSource: loginSourceRule,
+		},
+		{
+			name:       "last login of another cluster is ignored",
+			last:       &lastLogins{Clusters: map[string]map[string]lastLogin{"staging": {"db-1": {Login: "admin"}}}},
+			want:       "root",
+			wantSource: loginSourcePriority,
+		},
+		{
+			name:       "login_priority fallback",
+			want:       "root",
+			wantSource: loginSourcePriority,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestEnv(t, &fakeTsh{})
+			cfg.NodeLogins = tt.overrides
+			cfg.LoginRules = tt.rules
+			cfg.LoginPriority = []string{"root", "ubuntu"}
+
+			fallback := pickDefaultLogin([]string{"ubuntu", "root"})
+			got, source := resolveNodeLogin("prod", node, tt.last, fallback)
+			if got != tt.want || source != tt.wantSource {
+				t.Errorf("resolveNodeLogin() = %q (%s), want %q (%s)", got, source, tt.want, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestConfiguredNodeLoginUsesLabels(t *testing.T) {
+	fake := newTestCluster()
+	fake.nodes[defaultConfig().Proxy] = []tshNode{{Hostname: "db-1", Labels: map[string]string{"role": "db"}}}
+	useTestEnv(t, fake)
+	cfg.LoginRules = []LoginRule{{Labels: []string{"role=db"}, Login: "postgres"}}
+
+	if got := configuredNodeLogin("db-1"); got != "postgres" {
+		t.Errorf("configuredNodeLogin(db-1) = %q, want postgres", got)
+	}
+	if got := configuredNodeLogin("web-1"); got != "" {
+		t.Errorf("configuredNodeLogin(web-1) = %q, want none", got)
+	}
+}
+
+func TestAssignNodeLogins(t *testing.T) {
+	useTestEnv(t, &fakeTsh{})
+	cfg.NodeLogins = map[string]string{"db-*": "postgres", "bad-1": "-oProxyCommand=x"}
+	cfg.LoginRules = []LoginRule{
+		{Labels: []string{"os=amazon"}, Login: "ec2-user"},
+		{Labels: []string{"os=ubuntu"}, Login: "ubuntu"},
+	}
+	if err := recordConnection(historyEntry{Cluster: "default", Node: "web-1", Login: "admin", Time: time.Now(), Connected: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	state := testClusterState()
+	state.nodes = []tshNode{
+		{Hostname: "db-1"},
+		{Hostname: "web-1", Labels: map[string]string{"os": "amazon"}},
+		{Hostname: "aws-1", Labels: map[string]string{"os": "amazon"}},
+		{Hostname: "app-1", Labels: map[string]string{"os": "ubuntu"}},
+		{Hostname: "plain-1"},
+		{Hostname: "bad-1"},
+	}
+	aliases := map[string][]nodeAlias{}
+	for _, node := range state.nodes {
+		aliases["default"] = append(aliases["default"], nodeAlias{Alias: node.Hostname, Hostname: node.Hostname})
+	}
+
+	output := captureOutput(t, func() {
+		assignNodeLogins([]clusterState{state}, aliases)
+	})
+
+	// Only logins that differ from the cluster default (ubuntu) are set
+	want := map[string]string{
+		"db-1":    "postgres",
+		"web-1":   "admin",
+		"aws-1":   "ec2-user",
+		"app-1":   "",
+		"plain-1": "",
+		"bad-1":   "",
+	}
+	for _, alias := range aliases["default"] {
+		if alias.User != want[alias.Alias] {
+			t.Errorf("%s: User = %q, want %q", alias.Alias, alias.User, want[alias.Alias])
+		}
+	}
+	for _, line := range []string{
+		"Warning: ignoring node_logins login for bad-1",
+		"Using node_logins for the login of 1 node(s) in default",
+		"Using last login for the login of 1 node(s) in default",
+		"Using login_rules for the login of 1 node(s) in default",
+	} {
+		if !strings.Contains(output, line) {
+			t.Errorf("output is missing %q:\n%s", line, output)
+		}
+	}
+}
+
+func TestValidateLogin(t *testing.T) {
+	valid := []string{"ubuntu", "ec2-user", "svc.deploy", "user@domain"}
+	invalid := []string{"", "-oProxyCommand=x", "a b", "a#b", `a"b`, "a\\b", "a\tb"}
+	for _, login := range valid {
+		if err := validateLogin(login); err != nil {
+			t.Errorf("validateLogin(%q) = %v, want nil", login, err)
+		}
+	}
+	for _, login := range invalid {
+		if err := validateLogin(login); err == nil {
+			t.Errorf("validateLogin(%q) = nil, want an error", login)
+		}
+	}
+}
+
+func TestUpdateNodesWritesNodeLogins(t *testing.T) {
+	home := useTestEnv(t, newTestCluster("db-1", "web-1"))
+	cfg.NodeLogins = map[string]string{"db-1": "postgres", "web-1": "ubuntu"}
+	captureOutput(t, func() {
+		if err := updateNodes(writeOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	generated := readTestFile(t, sshIncludePath(filepath.Join(home, ".ssh")))
+	if !strings.Contains(generated, "Host db-1\n    HostName db-1.teleport-iam.aies.scicom.dev\n    User postgres\n") {
+		t.Errorf("db-1 has no User postgres:\n%s", generated)
+	}
+	if !strings.Contains(generated, "Host web-1\n    HostName web-1.teleport-iam.aies.scicom.dev\n\n") {
+		t.Errorf("web-1 should use the default login without its own User:\n%s", generated)
+	}
+}
@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// legacyProxy is the retired proxy used by the old setup scripts
+	legacyProxy = "teleport.aies.scicom.dev"
+
+	// Markers written by tsh config (via scripts/setup-teleport-ssh.sh) and
+	// by scripts/update-teleport-nodes.sh
+	legacyTshStart   = "# Begin generated Teleport configuration"
+	legacyTshEnd     = "# End generated Teleport configuration"
+	legacyNodesStart = "# BEGIN AUTO-GENERATED TELEPORT NODES"
+	legacyNodesEnd   = "# END AUTO-GENERATED TELEPORT NODES"
+)
+
+// legacyComments are the comments scripts/setup-teleport-ssh.sh wrote above its wildcard hosts
+var legacyComments = []string{
+	"# Custom aliases - add ip-* to Teleport patterns",
+	"# Wildcard alias definition",
+}
+
+// legacyHosts are the Host lines scripts/setup-teleport-ssh.sh wrote and the
+// only directives it put under each
+var legacyHosts = []struct {
+	patterns   string
+	directives []string
+}{
+	{"ip-* *." + legacyProxy + " " + legacyProxy, []string{"userknownhostsfile", "identityfile", "certificatefile"}},
+	{"ip-* *." + legacyProxy + " !" + legacyProxy, []string{"port", "proxycommand"}},
+	{"ip-*", []string{"hostname", "user"}},
+}
+
+// legacySection is a block of ~/.ssh/config written by the old bash scripts
+type legacySection struct {
+	description string
+	from, to    int // line numbers, inclusive
+	text        string
+}
+
+var migrateDryRun bool
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Remove SSH config left by the old setup scripts",
+	Long: `Remove SSH config left by the old setup scripts.
+
+scripts/setup-teleport-ssh.sh and scripts/update-teleport-nodes.sh wrote tsh
+config output, ip-* wildcard hosts and node aliases for the retired
+teleport.aies.scicom.dev proxy into ~/.ssh/config. They shadow the hosts
+generated by update-nodes. This command shows them, backs up ~/.ssh/config
+and removes them; run update-nodes afterwards to generate the current hosts.
+
+update-nodes also does this automatically.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return migrateLegacyConfig(writeOptions{dryRun: migrateDryRun})
+	},
+}
+
+func init() {
+	migrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "show what would be removed without changing ~/.ssh/config")
+	rootCmd.AddCommand(migrateCmd)
+}
+
+// findLegacySections finds the blocks written by the old bash scripts
+// outside scicom-helper's own managed sections
+func findLegacySections(file *sshConfigFile) ([]legacySection, []sshConfigProblem) {
+	lines := file.lines()
+	var sections []legacySection
+	var problems []sshConfigProblem
+	claimed := map[int]bool{}
+
+	// Marker-delimited sections
+	markers := []struct{ start, end, description string }{
+		{legacyTshStart, legacyTshEnd, "tsh config output from setup-teleport-ssh.sh"},
+		{legacyNodesStart, legacyNodesEnd, "node aliases from update-teleport-nodes.sh"},
+	}
+	for _, marker := range markers {
+		for i := 0; i < len(lines); i++ {
+			if !strings.HasPrefix(lines[i].text(), marker.start) {
+				continue
+			}
+			end := -1
+			for j := i + 1; j < len(lines); j++ {
+				if strings.HasPrefix(lines[j].text(), marker.end) {
+					end = j
+					break
+				}
+			}
+			if end == -1 {
+				problems = append(problems, sshConfigProblem{
+					line:    lines[i].num,
+					message: fmt.Sprintf("%q has no matching %q; remove it by hand", marker.start, marker.end),
+				})
+				break
+			}
+			sections = append(sections, newLegacySection(marker.description, lines, i, end, claimed))
+			i = end
+		}
+	}
+
+	// Wildcard hosts pointing at the retired proxy
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if claimed[line.num] || line.keyword != "host" {
+			continue
+		}
+		if !isLegacyHostBlock(lines, i) {
+			if mentionsLegacyProxy(lines, i) {
+				problems = append(problems, sshConfigProblem{
+					line:    line.num,
+					message: fmt.Sprintf("%q refers to the retired proxy %s but was not written by the old setup scripts; it is kept", line.text(), legacyProxy),
+				})
+			}
+			continue
+		}
+
+		// The block ends at its last directive
+		end := i
+		for j := i + 1; j < len(lines) && lines[j].keyword != "host" && lines[j].keyword != "match"; j++ {
+			if lines[j].keyword != "" {
+				end = j
+			}
+		}
+
+		// Take the script's comments right above it
+		start := i
+		for start > 0 && isLegacyComment(lines[start-1]) && !claimed[lines[start-1].num] {
+			start--
+		}
+		sections = append(sections, newLegacySection("wildcard host for "+legacyProxy+" from setup-teleport-ssh.sh", lines, start, end, claimed))
+		i = end
+	}
+
+	sort.Slice(sections, func(i, j int) bool {
+		return sections[i].from < sections[j].from
+	})
+	return sections, problems
+}
+
+// newLegacySection records lines[start:end+1] as a legacy section, together
+// with a blank line right above it that the scripts added as a separator
+func newLegacySection(description string, lines []sshLine, start, end int, claimed map[int]bool) legacySection {
+	if start > 0 && lines[start-1].isBlank() && !claimed[lines[start-1].num] {
+		start--
+	}
+
+	var text strings.Builder
+	for _, line := range lines[start : end+1] {
+		text.WriteString(line.raw)
+		claimed[line.num] = true
+	}
+	return legacySection{
+		description: description,
+		from:        lines[start].num,
+		to:          lines[end].num,
+		text:        text.String(),
+	}
+}
+
+// isLegacyHostBlock reports whether the Host line at lines[i] is one of the
+// old script's wildcard hosts: the same patterns, and only the directives the
+// script wrote under them. A block the user wrote or changed by hand is kept.
+func isLegacyHostBlock(lines []sshLine, i int) bool {
+	patterns := strings.Join(lines[i].args, " ")
+	for _, host := range legacyHosts {
+		if patterns != host.patterns {
+			continue
+		}
+		directives := 0
+		for j := i + 1; j < len(lines) && lines[j].keyword != "host" && lines[j].keyword != "match"; j++ {
+			if lines[j].keyword == "" {
+				continue
+			}
+			if !slices.Contains(host.directives, lines[j].keyword) {
+				return false
+			}
+			if lines[j].keyword == "hostname" && (len(lines[j].args) != 1 || lines[j].args[0] != "%h."+legacyProxy) {
+				return false
+			}
+			directives++
+		}
+		return directives > 0
+	}
+	return false
+}
+
+// mentionsLegacyProxy reports whether the Host block at lines[i] names the
+// retired proxy in its patterns or HostName
+func mentionsLegacyProxy(lines []sshLine, i int) bool {
+	for _, pattern := range lines[i].args {
+		if strings.HasSuffix(strings.TrimPrefix(pattern, "!"), legacyProxy) {
+			return true
+		}
+	}
+	for j := i + 1; j < len(lines) && lines[j].keyword != "host" && lines[j].keyword != "match"; j++ {
+		if lines[j].keyword == "hostname" && len(lines[j].args) > 0 && strings.HasSuffix(lines[j].args[0], "."+legacyProxy) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLegacyComment reports whether line is a comment written by the old scripts
+func isLegacyComment(line sshLine) bool {
+	for _, comment := range legacyComments {
+		if line.text() == comment {
+			return true
+		}
+	}
+	return false
+}
+
+// removeLegacySections shows the legacy sections and removes them from file
+func removeLegacySections(file *sshConfigFile, sections []legacySection) {
+	for _, section := range sections {
+		fmt.Printf("Found %s (lines %d-%d):\n", section.description, section.from, section.to)
+		for _, line := range splitLines(section.text) {
+			fmt.Printf("  | %s\n", strings.TrimRight(line, "\r\n"))
+		}
+		fmt.Println()
+	}
+	for _, section := range sections {
+		file.removeLineRange(section.from, section.to)
+	}
+	file.trimTrailingBlank()
+}
+
+// migrateLegacyConfig removes the old scripts' sections from ~/.ssh/config
+func migrateLegacyConfig(opts writeOptions) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %v", err)
+	}
+	sshConfig := filepath.Join(home, ".ssh", "config")
+
+	unlock, err := acquireLock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(sshConfig)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Println("No ~/.ssh/config found, nothing to migrate")
+			return nil
+		}
+		return fmt.Errorf("failed to read SSH config: %v", err)
+	}
+
+	file := parseSSHConfig(string(data))
+	sections, problems := findLegacySections(file)
+	for _, problem := range problems {
+		fmt.Printf("Warning: %s: %s\n", sshConfig, problem)
+	}
+	if len(sections) == 0 {
+		fmt.Println("✓ No configuration from the old setup scripts found in ~/.ssh/config")
+		return nil
+	}
+
+	removeLegacySections(file, sections)
+
+	change := fileChange{
+		path:    sshConfig,
+		oldData: data,
+		newData: []byte(file.String()),
+		perm:    0600,
+		backup: func() error {
+			entry, err := saveBackup(sshConfig, data, 0600, "migrate")
+			if err != nil {
+				return fmt.Errorf("failed to create backup: %v", err)
+			}
+			fmt.Printf("Backed up existing SSH config (backup %s)\n", entry.ID)
+			return nil
+		},
+	}
+	result, err := applyFileChange(change, opts)
+	if err != nil {
+		return fmt.Errorf("failed to write SSH config: %v", err)
+	}
+	if result != changeWritten {
+		return nil
+	}
+
+	fmt.Printf("✓ Removed %d section(s) left by the old setup scripts\n", len(sections))
+	fmt.Println("Run 'scicom-helper update-nodes' to generate the hosts for the current proxy")
+	return nil
+}
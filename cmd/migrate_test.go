@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+// legacySetupOutput is what scripts/setup-teleport-ssh.sh appended to ~/.ssh/config
+const legacySetupOutput = `
+# Begin generated Teleport configuration for teleport.aies.scicom.dev by tsh
+
+# Common flags for all teleport.aies.scicom.dev hosts
+Host *.teleport.aies.scicom.dev teleport.aies.scicom.dev
+    UserKnownHostsFile "/home/alice/.tsh/known_hosts"
+
+# End generated Teleport configuration
+
+# Custom aliases - add ip-* to Teleport patterns
+Host ip-* *.teleport.aies.scicom.dev teleport.aies.scicom.dev
+    UserKnownHostsFile "/home/alice/.tsh/known_hosts"
+    IdentityFile "/home/alice/.tsh/keys/teleport.aies.scicom.dev/alice"
+    CertificateFile "/home/alice/.tsh/keys/teleport.aies.scicom.dev/alice-ssh/teleport.aies.scicom.dev-cert.pub"
+
+Host ip-* *.teleport.aies.scicom.dev !teleport.aies.scicom.dev
+    Port 3022
+    ProxyCommand "/usr/local/bin/tsh" proxy ssh --cluster=teleport.aies.scicom.dev --proxy=teleport.aies.scicom.dev:443 %r@%h:%p
+
+# Wildcard alias definition
+Host ip-*
+    HostName %h.teleport.aies.scicom.dev
+    User ubuntu
+`
+
+// legacyNodesOutput is what scripts/update-teleport-nodes.sh appended
+const legacyNodesOutput = `
+# BEGIN AUTO-GENERATED TELEPORT NODES
+# Auto-generated Teleport node aliases
+# Last updated: Mon Jan  1 10:00:00 UTC 2024
+# Run update-teleport-nodes.sh to refresh this section
+
+Host ip-10-0-1-5
+    HostName ip-10-0-1-5.teleport.aies.scicom.dev
+    User ubuntu
+
+# END AUTO-GENERATED TELEPORT NODES
+`
+
+func TestFindLegacySections(t *testing.T) {
+	const user = "Host github.com\n    User git\n"
+	tests := []struct {
+		name         string
+		config       string
+		want         string
+		wantSections int
+		wantProblem  string
+	}{
+		{
+			name:         "setup script output",
+			config:       user + legacySetupOutput,
+			want:         user,
+			wantSections: 4,
+		},
+		{
+			name:         "node aliases",
+			config:       user + legacyNodesOutput + "\nHost work\n    User me\n",
+			want:         user + "\nHost work\n    User me\n",
+			wantSections: 1,
+		},
+		{
+			name:        "hand-written host for the retired proxy",
+			config:      user + "\nHost *.teleport.aies.scicom.dev\n    ForwardAgent yes\n",
+			want:        user + "\nHost *.teleport.aies.scicom.dev\n    ForwardAgent yes\n",
+			wantProblem: "was not written by the old setup scripts",
+		},
+		{
+			name:        "script block edited by hand",
+			config:      user + "\nHost ip-*\n    HostName %h.teleport.aies.scicom.dev\n    User ubuntu\n    LocalForward 8888 localhost:8888\n",
+			want:        user + "\nHost ip-*\n    HostName %h.teleport.aies.scicom.dev\n    User ubuntu\n    LocalForward 8888 localhost:8888\n",
+			wantProblem: "was not written by the old setup scripts",
+		},
+		{
+			name:   "current proxy",
+			config: user + "\nHost *.teleport-iam.aies.scicom.dev\n    ProxyCommand tsh proxy ssh %r@%h:%p\n",
+			want:   user + "\nHost *.teleport-iam.aies.scicom.dev\n    ProxyCommand tsh proxy ssh %r@%h:%p\n",
+		},
+		{
+			name:   "ip-* wildcard for another domain",
+			config: user + "\nHost ip-*\n    HostName %h.ec2.internal\n",
+			want:   user + "\nHost ip-*\n    HostName %h.ec2.internal\n",
+		},
+		{
+			name:        "start marker without end",
+			config:      user + "\n# BEGIN AUTO-GENERATED TELEPORT NODES\nHost ip-1\n    HostName ip-1.teleport.aies.scicom.dev\n",
+			want:        user + "\n# BEGIN AUTO-GENERATED TELEPORT NODES\nHost ip-1\n    HostName ip-1.teleport.aies.scicom.dev\n",
+			wantProblem: "has no matching",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := parseSSHConfig(tt.config)
+			sections, problems := findLegacySections(file)
+			if len(sections) != tt.wantSections {
+				t.Errorf("found %d legacy section(s), want %d: %+v", len(sections), tt.wantSections, sections)
+			}
+			var messages []string
+			for _, problem := range problems {
+				messages = append(messages, problem.String())
+			}
+			if got := strings.Join(messages, "\n"); tt.wantProblem == "" && got != "" || !strings.Contains(got, tt.wantProblem) {
+				t.Errorf("problems = %q, want %q", got, tt.wantProblem)
+			}
+
+			captureOutput(t, func() {
+				removeLegacySections(file, sections)
+			})
+			if got := file.String(); got != tt.want {
+				t.Errorf("after removal:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
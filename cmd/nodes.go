@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// nodeInventory is the node list of each cluster as of the last update-nodes run
+type nodeInventory struct {
+	Clusters map[string]clusterInventory `json:"clusters"`
+}
+
+// clusterInventory is the node list of one cluster
+type clusterInventory struct {
+	Updated time.Time       `json:"updated"`
+	Nodes   []inventoryNode `json:"nodes"`
+}
+
+// inventoryNode is a node as recorded in the inventory
+type inventoryNode struct {
+	Hostname string            `json:"hostname"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// inventoryFile is the saved inventory in the state directory
+const inventoryFile = "inventory.json"
+
+// loadInventory reads the saved inventory; a missing file is empty
+func loadInventory() (*nodeInventory, error) {
+	inventory := &nodeInventory{}
+	if err := loadJSONState(inventoryFile, inventory); err != nil {
+		return nil, err
+	}
+	if inventory.Clusters == nil {
+		inventory.Clusters = map[string]clusterInventory{}
+	}
+	return inventory, nil
+}
+
+// saveInventory writes the inventory
+func saveInventory(inventory *nodeInventory) error {
+	return saveJSONState(inventoryFile, inventory)
+}
+
+// inventoryNodes converts tsh nodes for the inventory, one entry per hostname
+func inventoryNodes(nodes []tshNode) []inventoryNode {
+	seen := map[string]bool{}
+	var result []inventoryNode
+	for _, node := range nodes {
+		if node.Hostname == "" || seen[node.Hostname] {
+			continue
+		}
+		seen[node.Hostname] = true
+		result = append(result, inventoryNode{Hostname: node.Hostname, Labels: node.Labels})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Hostname < result[j].Hostname
+	})
+	return result
+}
+
+// diffInventory returns the nodes added and removed between two node lists
+func diffInventory(previous, current []inventoryNode) ([]inventoryNode, []inventoryNode) {
+	old := map[string]bool{}
+	for _, node := range previous {
+		old[node.Hostname] = true
+	}
+	now := map[string]bool{}
+	for _, node := range current {
+		now[node.Hostname] = true
+	}
+
+	var added, removed []inventoryNode
+	for _, node := range current {
+		if !old[node.Hostname] {
+			added = append(added, node)
+		}
+	}
+	for _, node := range previous {
+		if !now[node.Hostname] {
+			removed = append(removed, node)
+		}
+	}
+	return added, removed
+}
+
+// formatLabels renders labels as sorted key=value pairs
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// printInventoryDiff prints the node changes of a cluster since the last refresh
+func printInventoryDiff(cluster string, previous clusterInventory, current []inventoryNode) {
+	added, removed := diffInventory(previous.Nodes, current)
+	since := previous.Updated.Format("2006-01-02 15:04")
+	if len(added) == 0 && len(removed) == 0 {
+		fmt.Printf("No node changes in %s since the last refresh (%s), %d node(s)\n", cluster, since, len(current))
+		return
+	}
+
+	printNode := func(mark string, node inventoryNode) {
+		if labels := formatLabels(node.Labels); labels != "" {
+			fmt.Printf("  %s %-30s %s\n", mark, node.Hostname, labels)
+		} else {
+			fmt.Printf("  %s %s\n", mark, node.Hostname)
+		}
+	}
+
+	if len(added) > 0 {
+		fmt.Printf("New in %s since the last refresh (%s):\n", cluster, since)
+		for _, node := range added {
+			printNode("+", node)
+		}
+	}
+	if len(removed) > 0 {
+		fmt.Printf("No longer accessible in %s:\n", cluster)
+		for _, node := range removed {
+			printNode("-", node)
+		}
+	}
+}
+
+var nodesCmd = &cobra.Command{
+	Use:   "nodes",
+	Short: "Inspect the Teleport nodes you have access to",
+}
+
+var nodesDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show nodes added or removed since the last update-nodes run",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireTsh(); err != nil {
+			return err
+		}
+
+		inventory, err := loadInventory()
+		if err != nil {
+			return err
+		}
+
+		checked := 0
+		for _, cluster := range cfg.clusterProfiles() {
+			if _, err := tshClient.Status(cluster.Proxy); err != nil {
+				fmt.Printf("Skipping cluster %s: not logged in\n", cluster.Name)
+				continue
+			}
+			nodes, err := tshClient.Ls(cluster.Proxy)
+			if err != nil {
+				return fmt.Errorf("cluster %s: failed to get nodes: %v", cluster.Name, err)
+			}
+			checked++
+
+			previous, ok := inventory.Clusters[cluster.Name]
+			if !ok {
+				fmt.Printf("No previous refresh of %s; run 'scicom-helper update-nodes' first\n", cluster.Name)
+				continue
+			}
+			printInventoryDiff(cluster.Name, previous, inventoryNodes(nodes))
+		}
+
+		if checked == 0 {
+			return fmt.Errorf("not logged in to Teleport")
+		}
+		return nil
+	},
+}
+
+func init() {
+	nodesCmd.AddCommand(nodesDiffCmd)
+	rootCmd.AddCommand(nodesCmd)
+}
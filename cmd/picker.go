@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+const (
+	// maxPickerColumns is the number of label columns picked automatically
+	maxPickerColumns = 3
+	// maxColumnWidth is the widest a label column gets in the node picker
+	maxColumnWidth = 20
+)
+
+// pickerSorts are the orders the node picker supports
+var pickerSorts = []string{"recent", "name"}
+
+// pickerColumns returns the label keys shown as columns in the node picker:
+// picker_labels if set, otherwise the keys most nodes have
+func pickerColumns(nodes []tshNode, configured []string) []string {
+	if len(configured) > 0 {
+		return configured
+	}
+
+	counts := map[string]int{}
+	for _, node := range nodes {
+		for key := range node.Labels {
+			counts[key]++
+		}
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > maxPickerColumns {
+		keys = keys[:maxPickerColumns]
+	}
+	return keys
+}
+
+// truncate shortens s to width characters
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// nodeOptions renders one picker line per node: the name followed by a
+// column for each label key
+func nodeOptions(nodes []tshNode, columns []string) []string {
+	nameWidth := 0
+	for _, node := range nodes {
+		if len(node.Hostname) > nameWidth {
+			nameWidth = len(node.Hostname)
+		}
+	}
+	widths := make([]int, len(columns))
+	for i, key := range columns {
+		for _, node := range nodes {
+			if width := len([]rune(truncate(node.Labels[key], maxColumnWidth))); width > widths[i] {
+				widths[i] = width
+			}
+		}
+	}
+
+	options := make([]string, len(nodes))
+	for n, node := range nodes {
+		line := fmt.Sprintf("%-*s", nameWidth, node.Hostname)
+		for i, key := range columns {
+			value := truncate(node.Labels[key], maxColumnWidth)
+			line += fmt.Sprintf("  %-*s", widths[i], value)
+		}
+		options[n] = strings.TrimRight(line, " ")
+	}
+	return options
+}
+
+// nodeSearchText is what the picker's search matches against
+func nodeSearchText(node tshNode) string {
+	return strings.Join([]string{node.Hostname, node.Addr, formatLabels(node.Labels)}, " ")
+}
+
+// fuzzyMatch reports whether every whitespace-separated term of query
+// appears in text as a subsequence, ignoring case: "pwb" matches "prod-web-1"
+func fuzzyMatch(query, text string) bool {
+	text = strings.ToLower(text)
+	for _, term := range strings.Fields(strings.ToLower(query)) {
+		rest := text
+		for _, r := range term {
+			i := strings.IndexRune(rest, r)
+			if i == -1 {
+				return false
+			}
+			rest = rest[i+len(string(r)):]
+		}
+	}
+	return true
+}
+
+// Markers of the picker's sections
+const (
+	pinnedMarker = "★"
+	recentMarker = "↺"
+)
+
+// orderNodes orders nodes for the picker: pinned nodes first, in the order
+// they were pinned, then the maxRecentNodes most recently used, then the
+// rest by name or, with picker_sort set to recent, by last use. It returns
+// the section marker of each node, or a space.
+func orderNodes(nodes []tshNode, cluster string, history *connectionHistory) ([]tshNode, []string) {
+	byName := map[string]tshNode{}
+	for _, node := range nodes {
+		byName[node.Hostname] = node
+	}
+
+	var ordered []tshNode
+	var markers []string
+	placed := map[string]bool{}
+	add := func(name, marker string) {
+		if node, ok := byName[name]; ok && !placed[name] {
+			placed[name] = true
+			ordered = append(ordered, node)
+			markers = append(markers, marker)
+		}
+	}
+
+	for _, name := range cfg.favoriteNodes(cluster) {
+		add(name, pinnedMarker)
+	}
+	used := history.lastUsed(cluster)
+	for _, name := range history.recentNodes(cluster, maxRecentNodes) {
+		add(name, recentMarker)
+	}
+
+	rest := make([]tshNode, 0, len(nodes))
+	for _, node := range nodes {
+		if !placed[node.Hostname] {
+			rest = append(rest, node)
+		}
+	}
+	sort.SliceStable(rest, func(i, j int) bool {
+		if cfg.PickerSort == "recent" {
+			ti, tj := used[rest[i].Hostname], used[rest[j].Hostname]
+			if !ti.Equal(tj) {
+				return ti.After(tj)
+			}
+		}
+		return rest[i].Hostname < rest[j].Hostname
+	})
+	for _, node := range rest {
+		add(node.Hostname, " ")
+	}
+	return ordered, markers
+}
+
+// pickNode shows a searchable list of nodes with label columns, pinned and
+// recently used nodes first, and returns the chosen one
+func pickNode(nodes []tshNode) (tshNode, error) {
+	history, err := loadHistory()
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		history = &connectionHistory{}
+	}
+
+	sorted, markers := orderNodes(nodes, cfg.activeCluster().Name, history)
+	columns := pickerColumns(sorted, cfg.PickerLabels)
+	options := nodeOptions(sorted, columns)
+	for i := range options {
+		options[i] = markers[i] + " " + options[i]
+	}
+
+	message := "Select a node to connect to"
+	if len(columns) > 0 {
+		message += fmt.Sprintf(" [name, %s]", strings.Join(columns, ", "))
+	}
+	message += fmt.Sprintf(" (%s pinned, %s recent; type to search names, labels and addresses):", pinnedMarker, recentMarker)
+
+	var index int
+	prompt := &survey.Select{
+		Message:  message,
+		Options:  options,
+		PageSize: 15,
+		Filter: func(filter, value string, i int) bool {
+			return fuzzyMatch(filter, nodeSearchText(sorted[i]))
+		},
+	}
+	if err := survey.AskOne(prompt, &index); err != nil {
+		return tshNode{}, fmt.Errorf("selection cancelled")
+	}
+	return sorted[index], nil
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		query string
+		text  string
+		want  bool
+	}{
+		{"", "prod-web-1", true},
+		{"pwb", "prod-web-1", true},
+		{"PWB", "prod-web-1", true},
+		{"bwp", "prod-web-1", false},
+		{"web env=prod", "web-1 10.0.0.5:3022 env=prod team=web", true},
+		{"web env=dev", "web-1 10.0.0.5:3022 env=prod team=web", false},
+		{"10.0.0.5", "web-1 10.0.0.5:3022 env=prod", true},
+		{"wéb", "wéb-1", true},
+		{"webb", "web-1", false},
+	}
+	for _, tt := range tests {
+		if got := fuzzyMatch(tt.query, tt.text); got != tt.want {
+			t.Errorf("fuzzyMatch(%q, %q) = %v, want %v", tt.query, tt.text, got, tt.want)
+		}
+	}
+}
+
+// testHistory returns a history where nodes were used in order, oldest first
+func testHistory(cluster string, nodes ...string) *connectionHistory {
+	history := &connectionHistory{}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, node := range nodes {
+		history.Entries = append(history.Entries, historyEntry{Cluster: cluster, Node: node, Login: "ubuntu", Time: start.Add(time.Duration(i) * time.Minute), Connected: true})
+	}
+	return history
+}
+
+// orderedNames renders orderNodes output as "marker name" pairs
+func orderedNames(nodes []tshNode, markers []string) string {
+	var names []string
+	for i, node := range nodes {
+		names = append(names, markers[i]+node.Hostname)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestOrderNodes(t *testing.T) {
+	var nodes []tshNode
+	for _, name := range []string{"web-3", "db-1", "web-1", "gpu-1", "web-2", "cache-1", "app-1", "app-2", "app-3", "app-4"} {
+		nodes = append(nodes, tshNode{Hostname: name})
+	}
+
+	tests := []struct {
+		name      string
+		favorites []FavoriteNode
+		history   *connectionHistory
+		sort      string
+		want      string
+	}{
+		{
+			name: "by name without history",
+			want: " app-1, app-2, app-3, app-4, cache-1, db-1, gpu-1, web-1, web-2, web-3",
+		},
+		{
+			name:      "pinned in pin order, then recent, then by name",
+			favorites: []FavoriteNode{{Cluster: "prod", Node: "web-2"}, {Cluster: "prod", Node: "db-1"}, {Cluster: "staging", Node: "gpu-1"}, {Cluster: "prod", Node: "gone"}},
+			history:   testHistory("prod", "gpu-1", "web-2", "cache-1"),
+			want:      "★web-2,★db-1,↺cache-1,↺gpu-1, app-1, app-2, app-3, app-4, web-1, web-3",
+		},
+		{
+			name:    "at most five recent nodes",
+			history: testHistory("prod", "app-1", "app-2", "app-3", "app-4", "web-1", "web-2", "web-3"),
+			want:    "↺web-3,↺web-2,↺web-1,↺app-4,↺app-3, app-1, app-2, cache-1, db-1, gpu-1",
+		},
+		{
+			name:    "picker_sort recent orders the rest by last use",
+			history: testHistory("prod", "db-1", "app-1", "app-2", "app-3", "app-4", "web-1", "web-2"),
+			sort:    "recent",
+			want:    "↺web-2,↺web-1,↺app-4,↺app-3,↺app-2, app-1, db-1, cache-1, gpu-1, web-3",
+		},
+		{
+			name:    "history of other clusters is ignored",
+			history: testHistory("staging", "web-3"),
+			want:    " app-1, app-2, app-3, app-4, cache-1, db-1, gpu-1, web-1, web-2, web-3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestEnv(t, &fakeTsh{})
+			cfg.Favorites = tt.favorites
+			cfg.PickerSort = tt.sort
+			history := tt.history
+			if history == nil {
+				history = &connectionHistory{}
+			}
+			if got := orderedNames(orderNodes(nodes, "prod", history)); got != tt.want {
+				t.Errorf("orderNodes() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchKeepsPickerOrder(t *testing.T) {
+	useTestEnv(t, &fakeTsh{})
+	cfg.Favorites = []FavoriteNode{{Cluster: "prod", Node: "web-9"}}
+	var nodes []tshNode
+	for i := 1; i <= 9; i++ {
+		nodes = append(nodes, tshNode{Hostname: fmt.Sprintf("web-%d", i), Labels: map[string]string{"env": "prod"}})
+	}
+	nodes = append(nodes, tshNode{Hostname: "db-1", Labels: map[string]string{"env": "prod"}})
+
+	// Matches are listed in picker order: pinned, then recent, then the rest
+	sorted, markers := orderNodes(nodes, "prod", testHistory("prod", "web-5"))
+	var matched []tshNode
+	var matchedMarkers []string
+	for i, node := range sorted {
+		if fuzzyMatch("web prod", nodeSearchText(node)) {
+			matched = append(matched, node)
+			matchedMarkers = append(matchedMarkers, markers[i])
+		}
+	}
+	if got, want := orderedNames(matched, matchedMarkers), "★web-9,↺web-5, web-1, web-2, web-3, web-4, web-6, web-7, web-8"; got != want {
+		t.Errorf("search results = %s, want %s", got, want)
+	}
+}
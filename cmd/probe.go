@@ -0,0 +1,332 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginProbeTimeout bounds a single `tsh ssh login@node exit`
+var loginProbeTimeout = 5 * time.Second
+
+const (
+	// loginProbeWorkers is the number of logins probed at the same time
+	loginProbeWorkers = 4
+	// maxProbeDetail is the longest stderr excerpt kept from a probe
+	maxProbeDetail = 120
+)
+
+// probeStatus is what testing a login on a node found out
+type probeStatus int
+
+const (
+	probeUnknown probeStatus = iota // failed for a reason we don't recognise
+	probeAllowed                    // the login opened a session
+	probeDenied                     // the node or the roles reject the login
+	probeOffline                    // the node could not be reached
+	probeExpired                    // the Teleport session needs a new login
+	probeTimeout                    // no answer within loginProbeTimeout
+)
+
+func (s probeStatus) String() string {
+	switch s {
+	case probeAllowed:
+		return "allowed"
+	case probeDenied:
+		return "access denied"
+	case probeOffline:
+		return "node offline"
+	case probeExpired:
+		return "session expired"
+	case probeTimeout:
+		return "timed out"
+	default:
+		return "unknown"
+	}
+}
+
+// mark returns the symbol shown next to a login with this status
+func (s probeStatus) mark() string {
+	switch s {
+	case probeAllowed:
+		return "✓"
+	case probeDenied:
+		return "✗"
+	default:
+		return "?"
+	}
+}
+
+// probeMessages map tsh error output to a probe status; earlier entries win,
+// since an expired certificate is also reported as an access failure
+var probeMessages = []struct {
+	status   probeStatus
+	patterns []string
+}{
+	{probeExpired, []string{"expired", "please login again", "please relogin", "not logged in", "tsh login"}},
+	{probeDenied, []string{"access denied", "permission denied", "not allowed", "unauthorized", "no such user", "unknown user"}},
+	{probeOffline, []string{
+		"failed to dial", "connection refused", "no route to host", "host is unreachable",
+		"network is unreachable", "no such host", "node not found", "host not found", "offline",
+		"connection reset", "i/o timeout",
+	}},
+}
+
+// loginProbe is the outcome of testing one login on a node
+type loginProbe struct {
+	login  string
+	status probeStatus
+	// detail is an excerpt of tsh's error output, if any
+	detail string
+	// cached is when the result was probed, if it came from the probe cache
+	cached time.Time
+}
+
+// String describes the probe for the login picker, e.g. "root ✗ access denied"
+func (p loginProbe) String() string {
+	if !p.cached.IsZero() {
+		uncached := p
+		uncached.cached = time.Time{}
+		return fmt.Sprintf("%s (tested %s)", uncached, p.cached.Format("Jan 2 15:04"))
+	}
+	if p.status == probeAllowed {
+		return fmt.Sprintf("%s %s", p.login, p.status.mark())
+	}
+	if strings.Contains(strings.ToLower(p.detail), p.status.String()) {
+		return fmt.Sprintf("%s %s %s", p.login, p.status.mark(), p.detail)
+	}
+	if p.detail != "" {
+		return fmt.Sprintf("%s %s %s: %s", p.login, p.status.mark(), p.status, p.detail)
+	}
+	return fmt.Sprintf("%s %s %s", p.login, p.status.mark(), p.status)
+}
+
+// classifyProbe maps tsh's error output to a probe status
+func classifyProbe(stderr string) probeStatus {
+	output := strings.ToLower(stderr)
+	for _, message := range probeMessages {
+		for _, pattern := range message.patterns {
+			if strings.Contains(output, pattern) {
+				return message.status
+			}
+		}
+	}
+	return probeUnknown
+}
+
+// probeDetail returns the last non-empty line of stderr, shortened
+func probeDetail(stderr string) string {
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	detail := strings.TrimSpace(lines[len(lines)-1])
+	detail = strings.TrimPrefix(detail, "ERROR: ")
+	if len(detail) > maxProbeDetail {
+		detail = detail[:maxProbeDetail-3] + "..."
+	}
+	return detail
+}
+
+// testLoginAccess tests if a specific login works for a node
+func testLoginAccess(ctx context.Context, login, nodeName string) loginProbe {
+	ctx, cancel := context.WithTimeout(ctx, loginProbeTimeout)
+	defer cancel()
+
+	// The "exit" command will close immediately if login is allowed
+	var stderr bytes.Buffer
+	err := tshClient.SSH(ctx, sshSession{
+		Proxy:   cfg.activeCluster().Proxy,
+		Target:  fmt.Sprintf("%s@%s", login, nodeName),
+		Command: []string{"exit"},
+		Stderr:  &stderr,
+	})
+
+	probe := loginProbe{login: login, status: probeAllowed}
+	switch {
+	case err == nil:
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		probe.status = probeTimeout
+	case errors.Is(ctx.Err(), context.Canceled):
+		probe.status = probeUnknown
+		probe.detail = "cancelled"
+	default:
+		probe.status = classifyProbe(stderr.String())
+		probe.detail = probeDetail(stderr.String())
+		if probe.detail == "" {
+			probe.detail = err.Error()
+		}
+	}
+	return probe
+}
+
+// probeLogins tests logins on a node with a bounded pool of workers.
+// onResult is called from the calling goroutine as each probe finishes;
+// the returned results are in the order of logins.
+func probeLogins(ctx context.Context, nodeName string, logins []string, onResult func(loginProbe)) []loginProbe {
+	jobs := make(chan int)
+	type result struct {
+		index int
+		probe loginProbe
+	}
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	workers := loginProbeWorkers
+	if len(logins) < workers {
+		workers = len(logins)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// Don't start tsh for a job taken after cancellation
+				if ctx.Err() != nil {
+					continue
+				}
+				results <- result{index: i, probe: testLoginAccess(ctx, logins[i], nodeName)}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := range logins {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	probes := make([]loginProbe, len(logins))
+	done := make([]bool, len(logins))
+	for r := range results {
+		probes[r.index] = r.probe
+		done[r.index] = true
+		if onResult != nil {
+			onResult(r.probe)
+		}
+	}
+
+	// Logins never probed because ctx was cancelled
+	for i, login := range logins {
+		if !done[i] {
+			probes[i] = loginProbe{login: login, status: probeUnknown, detail: "cancelled"}
+		}
+	}
+	return probes
+}
+
+// getNodeLogins returns the result of every login from tsh status on the
+// node, allowed logins first. Results cached for the current certificate and
+// roles are reused unless reprobe is set; the other logins are tested.
+func getNodeLogins(nodeName string, reprobe bool) ([]loginProbe, error) {
+	cluster := cfg.activeCluster()
+	status, err := tshClient.Status(cluster.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	if len(status.Logins) == 0 {
+		return nil, fmt.Errorf("no logins found")
+	}
+	id := newProbeIdentity(cluster.Name, status)
+
+	cache, err := loadProbeCache()
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		cache = &probeCache{}
+	}
+
+	probes := make([]loginProbe, len(status.Logins))
+	var untested []string
+	for i, login := range status.Logins {
+		if probe, ok := cache.lookup(id, nodeName, login); ok && !reprobe {
+			probes[i] = probe
+			continue
+		}
+		untested = append(untested, login)
+	}
+
+	if cached := len(status.Logins) - len(untested); cached > 0 {
+		fmt.Printf("Using earlier test results for %d login(s) on %s\n", cached, nodeName)
+	}
+
+	if len(untested) > 0 {
+		// Test the logins concurrently, showing each result as it arrives
+		spin := startSpinner(fmt.Sprintf("Testing %d login(s) on %s...", len(untested), nodeName))
+		finished := 0
+		tested := probeLogins(context.Background(), nodeName, untested, func(probe loginProbe) {
+			finished++
+			spin.println("  " + probe.String())
+			spin.update(fmt.Sprintf("Testing logins on %s... %d/%d", nodeName, finished, len(untested)))
+		})
+		spin.stop()
+
+		results := map[string]loginProbe{}
+		for _, probe := range tested {
+			results[probe.login] = probe
+		}
+		for i, login := range status.Logins {
+			if probe, ok := results[login]; ok {
+				probes[i] = probe
+			}
+		}
+		if err := saveProbeResults(id, nodeName, tested); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+
+	sortProbes(probes)
+	return probes, nil
+}
+
+// probedAllowed reports whether probes found login allowed
+func probedAllowed(probes []loginProbe, login string) bool {
+	for _, probe := range probes {
+		if probe.login == login {
+			return probe.status == probeAllowed
+		}
+	}
+	return false
+}
+
+// usableLogins returns the logins that are allowed on the node. Only if the
+// probes were all inconclusive (unknown or timed out), those logins are
+// returned instead, since they may still work.
+func usableLogins(probes []loginProbe) []string {
+	var allowed, inconclusive []string
+	for _, probe := range probes {
+		switch probe.status {
+		case probeAllowed:
+			allowed = append(allowed, probe.login)
+		case probeUnknown, probeTimeout:
+			inconclusive = append(inconclusive, probe.login)
+		}
+	}
+	if len(allowed) > 0 {
+		return allowed
+	}
+	return inconclusive
+}
+
+// probeFailure explains why none of the probed logins can be used
+func probeFailure(nodeName string, probes []loginProbe) error {
+	for _, probe := range probes {
+		if probe.status == probeExpired {
+			return fmt.Errorf("Teleport session expired, run 'scicom-helper login' again")
+		}
+	}
+	for _, probe := range probes {
+		if probe.status == probeOffline {
+			return fmt.Errorf("%s is not reachable: %s", nodeName, probe.detail)
+		}
+	}
+	return fmt.Errorf("no login is allowed on %s", nodeName)
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// probeCacheTTL bounds cached results when tsh doesn't report the
+// certificate expiry
+const probeCacheTTL = time.Hour
+
+// probeCache holds login-probe results that only change with the user's
+// certificate or roles: allowed and denied
+type probeCache struct {
+	Entries []probeCacheEntry `json:"entries"`
+}
+
+// probeCacheEntry is the result of probing one login on one node
+type probeCacheEntry struct {
+	Cluster string    `json:"cluster"`
+	User    string    `json:"user"`
+	Node    string    `json:"node"`
+	Login   string    `json:"login"`
+	Allowed bool      `json:"allowed"`
+	Detail  string    `json:"detail,omitempty"`
+	Roles   string    `json:"roles"`
+	Checked time.Time `json:"checked"`
+	Expires time.Time `json:"expires"`
+}
+
+// probeIdentity is who the probes run as; cached results are only valid for
+// the same cluster, user and roles, until the certificate expires
+type probeIdentity struct {
+	cluster string
+	user    string
+	roles   string
+	expires time.Time
+}
+
+// newProbeIdentity describes the current Teleport session of cluster
+func newProbeIdentity(cluster string, status *tshStatus) probeIdentity {
+	roles := append([]string(nil), status.Roles...)
+	sort.Strings(roles)
+	expires := status.ValidUntil
+	if expires.IsZero() {
+		expires = time.Now().Add(probeCacheTTL)
+	}
+	return probeIdentity{cluster: cluster, user: status.Username, roles: strings.Join(roles, ","), expires: expires}
+}
+
+// probeCacheFile is the probe cache in the state directory
+const probeCacheFile = "probes.json"
+
+// loadProbeCache reads the probe cache; a missing file is empty
+func loadProbeCache() (*probeCache, error) {
+	cache := &probeCache{}
+	if err := loadJSONState(probeCacheFile, cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// lookup returns the cached result of login on node, if still valid for id
+func (c *probeCache) lookup(id probeIdentity, node, login string) (loginProbe, bool) {
+	now := time.Now()
+	for _, entry := range c.Entries {
+		if entry.Cluster != id.cluster || entry.User != id.user || entry.Node != node || entry.Login != login {
+			continue
+		}
+		if entry.Roles != id.roles || now.After(entry.Expires) {
+			return loginProbe{}, false
+		}
+		probe := loginProbe{login: login, status: probeDenied, detail: entry.Detail, cached: entry.Checked}
+		if entry.Allowed {
+			probe.status = probeAllowed
+		}
+		return probe, true
+	}
+	return loginProbe{}, false
+}
+
+// saveProbeResults stores the allowed and denied results of probing node,
+// replacing older results, and drops expired entries
+func saveProbeResults(id probeIdentity, node string, probes []loginProbe) error {
+	unlock, err := acquireLock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cache, err := loadProbeCache()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	probed := map[string]bool{}
+	var entries []probeCacheEntry
+	for _, probe := range probes {
+		probed[probe.login] = true
+		// Results of an expired certificate would never be used
+		if probe.status != probeAllowed && probe.status != probeDenied || !now.Before(id.expires) {
+			continue
+		}
+		entries = append(entries, probeCacheEntry{
+			Cluster: id.cluster,
+			User:    id.user,
+			Node:    node,
+			Login:   probe.login,
+			Allowed: probe.status == probeAllowed,
+			Detail:  probe.detail,
+			Roles:   id.roles,
+			Checked: now,
+			Expires: id.expires,
+		})
+	}
+	for _, entry := range cache.Entries {
+		current := entry.Cluster == id.cluster && entry.User == id.user && entry.Node == node && probed[entry.Login]
+		if !current && now.Before(entry.Expires) {
+			entries = append(entries, entry)
+		}
+	}
+	cache.Entries = entries
+	return saveJSONState(probeCacheFile, cache)
+}
+
+// probeRank orders logins in the picker: allowed first, denied last
+func probeRank(status probeStatus) int {
+	switch status {
+	case probeAllowed:
+		return 0
+	case probeUnknown, probeTimeout:
+		return 1
+	case probeOffline, probeExpired:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// sortProbes orders probes by probeRank, keeping the order of tsh status
+// within each rank
+func sortProbes(probes []loginProbe) {
+	sort.SliceStable(probes, func(i, j int) bool {
+		return probeRank(probes[i].status) < probeRank(probes[j].status)
+	})
+}
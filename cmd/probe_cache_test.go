@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbeCacheLookup(t *testing.T) {
+	useTestEnv(t, &fakeTsh{})
+	status := &tshStatus{Username: "alice", Roles: []string{"dev", "access"}, ValidUntil: time.Now().Add(time.Hour)}
+	id := newProbeIdentity("prod", status)
+	if err := saveProbeResults(id, "web-1", []loginProbe{{login: "ubuntu", status: probeAllowed}, {login: "root", status: probeDenied, detail: "access denied"}}); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := loadProbeCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Role order doesn't matter
+	sameRoles := newProbeIdentity("prod", &tshStatus{Username: "alice", Roles: []string{"access", "dev"}, ValidUntil: status.ValidUntil})
+	if probe, ok := cache.lookup(sameRoles, "web-1", "ubuntu"); !ok || probe.status != probeAllowed || probe.cached.IsZero() {
+		t.Errorf("lookup(ubuntu) = %+v, %v, want a cached allowed result", probe, ok)
+	}
+	if probe, ok := cache.lookup(id, "web-1", "root"); !ok || probe.status != probeDenied || probe.detail != "access denied" {
+		t.Errorf("lookup(root) = %+v, %v, want a cached denied result", probe, ok)
+	}
+
+	misses := map[string]probeIdentity{
+		"other roles":   newProbeIdentity("prod", &tshStatus{Username: "alice", Roles: []string{"access", "admin"}, ValidUntil: status.ValidUntil}),
+		"other user":    newProbeIdentity("prod", &tshStatus{Username: "bob", Roles: status.Roles, ValidUntil: status.ValidUntil}),
+		"other cluster": newProbeIdentity("staging", status),
+	}
+	for name, other := range misses {
+		if _, ok := cache.lookup(other, "web-1", "ubuntu"); ok {
+			t.Errorf("%s: cached result was used", name)
+		}
+	}
+	if _, ok := cache.lookup(id, "web-2", "ubuntu"); ok {
+		t.Error("other node: cached result was used")
+	}
+}
+
+func TestProbeCacheExpiry(t *testing.T) {
+	useTestEnv(t, &fakeTsh{})
+	expired := newProbeIdentity("prod", &tshStatus{Username: "alice", ValidUntil: time.Now().Add(-time.Minute)})
+	if err := saveProbeResults(expired, "web-1", []loginProbe{{login: "ubuntu", status: probeAllowed}}); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := loadProbeCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.Entries) != 0 {
+		t.Errorf("results of an expired certificate were stored: %+v", cache.Entries)
+	}
+
+	// Without an expiry from tsh, results last probeCacheTTL
+	id := newProbeIdentity("prod", &tshStatus{Username: "alice"})
+	if until := time.Until(id.expires); until <= 0 || until > probeCacheTTL {
+		t.Errorf("default expiry in %v, want within %v", until, probeCacheTTL)
+	}
+	cache = &probeCache{Entries: []probeCacheEntry{{
+		Cluster: "prod", User: "alice", Node: "web-1", Login: "ubuntu", Allowed: true,
+		Expires: time.Now().Add(-time.Second),
+	}}}
+	if _, ok := cache.lookup(id, "web-1", "ubuntu"); ok {
+		t.Error("expired entry was used")
+	}
+}
+
+func TestSaveProbeResultsStoresConclusiveResults(t *testing.T) {
+	useTestEnv(t, &fakeTsh{})
+	id := newProbeIdentity("prod", &tshStatus{Username: "alice", ValidUntil: time.Now().Add(time.Hour)})
+
+	// An earlier allowed result is replaced even by an inconclusive probe
+	if err := saveProbeResults(id, "web-1", []loginProbe{{login: "admin", status: probeAllowed}, {login: "deploy", status: probeDenied}}); err != nil {
+		t.Fatal(err)
+	}
+	err := saveProbeResults(id, "web-1", []loginProbe{
+		{login: "ubuntu", status: probeAllowed},
+		{login: "root", status: probeDenied},
+		{login: "admin", status: probeTimeout},
+		{login: "ops", status: probeOffline},
+		{login: "svc", status: probeExpired},
+		{login: "guest", status: probeUnknown},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := loadProbeCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored []string
+	for _, entry := range cache.Entries {
+		stored = append(stored, entry.Login)
+	}
+	if got := strings.Join(stored, ","); got != "ubuntu,root,deploy" {
+		t.Errorf("stored logins %s, want ubuntu,root,deploy", got)
+	}
+}
+
+func TestGetNodeLoginsUsesCache(t *testing.T) {
+	fake := newTestCluster("web-1")
+	fake.allowed = map[string][]string{"web-1": {"ubuntu"}}
+	useTestEnv(t, fake)
+
+	// sshCalls probes web-1 and returns the number of tsh ssh runs
+	sshCalls := func(reprobe bool) int {
+		t.Helper()
+		fake.calls = nil
+		var probes []loginProbe
+		captureOutput(t, func() {
+			var err error
+			if probes, err = getNodeLogins("web-1", reprobe); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if len(probes) != 2 || probes[0].login != "ubuntu" || probes[0].status != probeAllowed || probes[1].status != probeDenied {
+			t.Errorf("getNodeLogins() = %+v, want ubuntu allowed, root denied", probes)
+		}
+		calls := 0
+		for _, call := range fake.calls {
+			if strings.HasPrefix(call, "ssh ") {
+				calls++
+			}
+		}
+		return calls
+	}
+
+	if got := sshCalls(false); got != 2 {
+		t.Errorf("first probe ran tsh ssh %d times, want 2", got)
+	}
+	if got := sshCalls(false); got != 0 {
+		t.Errorf("cached probe ran tsh ssh %d times, want 0", got)
+	}
+	if got := sshCalls(true); got != 2 {
+		t.Errorf("--reprobe ran tsh ssh %d times, want 2", got)
+	}
+
+	// New roles mean a new certificate, so the cache no longer applies
+	fake.profiles[defaultConfig().Proxy].Roles = []string{"admin"}
+	if got := sshCalls(false); got != 2 {
+		t.Errorf("probe after a role change ran tsh ssh %d times, want 2", got)
+	}
+}
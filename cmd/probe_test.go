@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shortProbeTimeout makes probes give up after d for the rest of the test
+func shortProbeTimeout(t *testing.T, d time.Duration) {
+	t.Helper()
+	saved := loginProbeTimeout
+	loginProbeTimeout = d
+	t.Cleanup(func() { loginProbeTimeout = saved })
+}
+
+func TestProbeLoginsTimeout(t *testing.T) {
+	useTestEnv(t, &fakeTsh{
+		allowed: map[string][]string{"web-1": {"ubuntu", "slow"}},
+		delays:  map[string]time.Duration{"slow": time.Minute},
+	})
+	shortProbeTimeout(t, 50*time.Millisecond)
+
+	var order []string
+	start := time.Now()
+	probes := probeLogins(context.Background(), "web-1", []string{"slow", "ubuntu", "root"}, func(probe loginProbe) {
+		order = append(order, probe.login)
+	})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("probing took %v, want about the probe timeout", elapsed)
+	}
+
+	want := []probeStatus{probeTimeout, probeAllowed, probeDenied}
+	for i, probe := range probes {
+		if probe.status != want[i] {
+			t.Errorf("%s: status %s, want %s", probe.login, probe.status, want[i])
+		}
+	}
+	// The other logins are reported without waiting for the slow one
+	if len(order) != 3 || order[2] != "slow" {
+		t.Errorf("results arrived in order %v, want slow last", order)
+	}
+}
+
+func TestProbeLoginsConcurrencyLimit(t *testing.T) {
+	var logins []string
+	delays := map[string]time.Duration{}
+	for i := 0; i < 3*loginProbeWorkers; i++ {
+		login := fmt.Sprintf("user%d", i)
+		logins = append(logins, login)
+		delays[login] = 20 * time.Millisecond
+	}
+	fake := &fakeTsh{allowed: map[string][]string{"web-1": logins}, delays: delays}
+	useTestEnv(t, fake)
+
+	probes := probeLogins(context.Background(), "web-1", logins, nil)
+	for _, probe := range probes {
+		if probe.status != probeAllowed {
+			t.Errorf("%s: status %s, want allowed", probe.login, probe.status)
+		}
+	}
+	if fake.maxRunning != loginProbeWorkers {
+		t.Errorf("%d probes ran at once, want %d", fake.maxRunning, loginProbeWorkers)
+	}
+}
+
+func TestProbeLoginsCancelled(t *testing.T) {
+	var logins []string
+	delays := map[string]time.Duration{}
+	for i := 0; i < 2*loginProbeWorkers; i++ {
+		login := fmt.Sprintf("user%d", i)
+		logins = append(logins, login)
+		delays[login] = time.Minute
+	}
+	fake := &fakeTsh{allowed: map[string][]string{"web-1": logins}, delays: delays}
+	useTestEnv(t, fake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	probes := probeLogins(ctx, "web-1", logins, nil)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled probing took %v", elapsed)
+	}
+
+	if len(probes) != len(logins) {
+		t.Fatalf("got %d results, want %d", len(probes), len(logins))
+	}
+	for i, probe := range probes {
+		if probe.login != logins[i] || probe.status != probeUnknown || probe.detail != "cancelled" {
+			t.Errorf("probe %d = %+v, want %s cancelled", i, probe, logins[i])
+		}
+	}
+	// Logins that never started were not run at all
+	if calls := len(fake.calls); calls != loginProbeWorkers {
+		t.Errorf("tsh ssh ran %d times, want %d", calls, loginProbeWorkers)
+	}
+}
+
+func TestClassifyProbe(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   probeStatus
+	}{
+		{"ERROR: access denied to root connecting to web-1 on cluster teleport.example.com\n", probeDenied},
+		{"ERROR: ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain\nERROR: access denied to admin connecting to web-1\n", probeDenied},
+		{"Failed to launch: user: unknown user deploy.\n", probeDenied},
+		{"ERROR: ssh: cert has expired\n", probeExpired},
+		{"ERROR: Your credentials have expired, please login again using `tsh login`\n", probeExpired},
+		{"ERROR: access denied: certificate expired, please relogin\n", probeExpired},
+		{"Not logged in.\n", probeExpired},
+		{"ERROR: failed to dial target host\n\tdial tcp 10.0.3.7:3022: connect: connection refused\n", probeOffline},
+		{"ERROR: dial tcp 10.0.3.7:3022: i/o timeout\n", probeOffline},
+		{"ERROR: dial tcp: lookup teleport.example.com: no such host\n", probeOffline},
+		{"ERROR: node web-9 is offline\n", probeOffline},
+		{"ERROR: EOF\n", probeUnknown},
+		{"", probeUnknown},
+	}
+	for _, tt := range tests {
+		if got := classifyProbe(tt.stderr); got != tt.want {
+			t.Errorf("classifyProbe(%q) = %s, want %s", tt.stderr, got, tt.want)
+		}
+	}
+}
+
+func TestProbeDetail(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   string
+	}{
+		{"ERROR: access denied to root connecting to web-1\n", "access denied to root connecting to web-1"},
+		{"Warning: something\n\nERROR: node offline\n\n", "node offline"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := probeDetail(tt.stderr); got != tt.want {
+			t.Errorf("probeDetail(%q) = %q, want %q", tt.stderr, got, tt.want)
+		}
+	}
+	if got := probeDetail("ERROR: " + strings.Repeat("x", 2*maxProbeDetail)); len(got) != maxProbeDetail || !strings.HasSuffix(got, "...") {
+		t.Errorf("long detail = %q (%d bytes), want %d bytes ending in ...", got, len(got), maxProbeDetail)
+	}
+}
+
+func TestTestLoginAccess(t *testing.T) {
+	useTestEnv(t, &fakeTsh{
+		allowed: map[string][]string{"web-1": {"ubuntu", "slow"}},
+		delays:  map[string]time.Duration{"slow": time.Minute},
+	})
+	shortProbeTimeout(t, 50*time.Millisecond)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		ctx        context.Context
+		login      string
+		want       probeStatus
+		wantDetail string
+	}{
+		{context.Background(), "ubuntu", probeAllowed, ""},
+		{context.Background(), "root", probeDenied, "access denied to root connecting to web-1"},
+		{context.Background(), "slow", probeTimeout, ""},
+		{cancelled, "ubuntu", probeUnknown, "cancelled"},
+	}
+	for _, tt := range tests {
+		probe := testLoginAccess(tt.ctx, tt.login, "web-1")
+		if probe.status != tt.want || probe.detail != tt.wantDetail {
+			t.Errorf("testLoginAccess(%s) = %s %q, want %s %q", tt.login, probe.status, probe.detail, tt.want, tt.wantDetail)
+		}
+	}
+}
+
+func TestProbeFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		probes []loginProbe
+		want   string
+	}{
+		{
+			name: "expired wins",
+			probes: []loginProbe{
+				{login: "root", status: probeOffline, detail: "connection refused"},
+				{login: "ubuntu", status: probeExpired},
+			},
+			want: "Teleport session expired, run 'scicom-helper login' again",
+		},
+		{
+			name:   "offline",
+			probes: []loginProbe{{login: "root", status: probeDenied}, {login: "ubuntu", status: probeOffline, detail: "connection refused"}},
+			want:   "web-1 is not reachable: connection refused",
+		},
+		{
+			name:   "all denied",
+			probes: []loginProbe{{login: "root", status: probeDenied}, {login: "ubuntu", status: probeDenied}},
+			want:   "no login is allowed on web-1",
+		},
+	}
+	for _, tt := range tests {
+		if got := probeFailure("web-1", tt.probes); got == nil || got.Error() != tt.want {
+			t.Errorf("%s: probeFailure() = %v, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUsableLogins(t *testing.T) {
+	tests := []struct {
+		name   string
+		probes []loginProbe
+		want   []string
+	}{
+		{
+			name:   "allowed only",
+			probes: []loginProbe{{login: "root", status: probeDenied}, {login: "ubuntu", status: probeAllowed}, {login: "admin", status: probeTimeout}},
+			want:   []string{"ubuntu"},
+		},
+		{
+			name:   "inconclusive when none is allowed",
+			probes: []loginProbe{{login: "root", status: probeDenied}, {login: "ubuntu", status: probeTimeout}, {login: "admin", status: probeUnknown}},
+			want:   []string{"ubuntu", "admin"},
+		},
+		{
+			name:   "offline and expired are not usable",
+			probes: []loginProbe{{login: "root", status: probeOffline}, {login: "ubuntu", status: probeExpired}},
+			want:   nil,
+		},
+	}
+	for _, tt := range tests {
+		if got := usableLogins(tt.probes); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: usableLogins() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package cmd
+
+import "os/exec"
+
+// killProcessGroupOnCancel keeps the default behaviour on Windows, where
+// context cancellation kills the process itself
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
@@ -8,15 +8,26 @@ import (
 	"github.com/spf13/cobra"
 )
 
-const (
-	teleportProxy = "teleport-iam.aies.scicom.dev"
-	githubAuth    = "github-connector"
-)
-
 var rootCmd = &cobra.Command{
 	Use:   "scicom-helper",
 	Short: "Scicom Platform Engineering helper tool",
-	Long:  `A CLI tool to help Scicom developers manage infrastructure access, starting with Teleport EC2 access.`,
+	Long: `A CLI tool to help Scicom developers manage infrastructure access, starting with Teleport EC2 access.
+
+Run without a subcommand to start the interactive menu.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		loaded, err := loadConfig()
+		if err != nil {
+			// Still allow fixing a broken config file
+			if cmd == configEditCmd {
+				return nil
+			}
+			return err
+		}
+		cfg = loaded
+		return nil
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		runInteractiveMode()
 	},
@@ -26,26 +37,38 @@ func Execute() error {
 	return rootCmd.Execute()
 }
 
+// requireTsh returns an error if the tsh binary cannot be found
+func requireTsh() error {
+	if !isTshInstalled() {
+		return fmt.Errorf("tsh (Teleport CLI) is not installed\nPlease install from: https://goteleport.com/download")
+	}
+	return nil
+}
+
 func runInteractiveMode() {
 	// Check if tsh is installed first
-	if !isTshInstalled() {
-		fmt.Println("Error: tsh (Teleport CLI) is not installed")
-		fmt.Println("Please install from: https://goteleport.com/download")
+	if err := requireTsh(); err != nil {
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 
 	for {
+		options := []string{
+			"Teleport Setup (GitHub SSO)",
+			"Teleport Setup (Local Account)",
+			"Teleport Update Nodes (Update SSH config)",
+			"Configure VS Code for Teleport",
+			"Teleport SSH (Connect to a node)",
+		}
+		if len(cfg.clusterProfiles()) > 1 {
+			options = append(options, "Switch Teleport Cluster")
+		}
+		options = append(options, "Exit")
+
 		var choice string
 		prompt := &survey.Select{
-			Message: "What would you like to do?",
-			Options: []string{
-				"Teleport Setup (GitHub SSO)",
-				"Teleport Setup (Local Account)",
-				"Teleport Update Nodes (Update SSH config)",
-				"Configure VS Code for Teleport",
-				"Teleport SSH (Connect to a node)",
-				"Exit",
-			},
+			Message: fmt.Sprintf("What would you like to do? (cluster: %s)", cfg.activeCluster().Name),
+			Options: options,
 		}
 
 		err := survey.AskOne(prompt, &choice)
@@ -64,17 +87,21 @@ func runInteractiveMode() {
 				fmt.Printf("Error: %v\n", err)
 			}
 		case "Teleport Update Nodes (Update SSH config)":
-			if err := updateNodes(); err != nil {
+			if err := updateNodes(writeOptions{confirm: true}); err != nil {
 				fmt.Printf("Error: %v\n", err)
 			}
 		case "Configure VS Code for Teleport":
-			if err := configureVSCode(); err != nil {
+			if err := configureVSCode(writeOptions{confirm: true}); err != nil {
 				fmt.Printf("Error: %v\n", err)
 			}
 		case "Teleport SSH (Connect to a node)":
 			if err := sshToNode(); err != nil {
 				fmt.Printf("Error: %v\n", err)
 			}
+		case "Switch Teleport Cluster":
+			if err := selectCluster(); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
 		case "Exit":
 			fmt.Println("Goodbye!")
 			return
@@ -0,0 +1,16 @@
+//go:build windows
+
+package cmd
+
+import "os"
+
+// preserveOwner is a no-op on Windows, where a file replaced by rename keeps
+// the ACLs inherited from its directory
+func preserveOwner(f *os.File, info os.FileInfo) error {
+	return nil
+}
+
+// syncDir is a no-op on Windows, which does not support syncing directories
+func syncDir(dir string) error {
+	return nil
+}
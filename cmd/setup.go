@@ -2,10 +2,40 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"os/exec"
+
+	"github.com/spf13/cobra"
 )
 
+var loginAuth string
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Log in to Teleport",
+	Long: `Log in to Teleport without going through the interactive menu.
+
+Use --auth=github for GitHub SSO (default) or --auth=local for a local Teleport account.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireTsh(); err != nil {
+			return err
+		}
+
+		switch loginAuth {
+		case "github":
+			return setupTeleportGitHub()
+		case "local":
+			return setupTeleportLocal()
+		default:
+			return fmt.Errorf("unknown auth method %q (expected github or local)", loginAuth)
+		}
+	},
+}
+
+func init() {
+	loginCmd.Flags().StringVar(&loginAuth, "auth", "github", "authentication method: github or local")
+	rootCmd.AddCommand(loginCmd)
+}
+
 // setupTeleportGitHub handles the Teleport login process using GitHub SSO
 func setupTeleportGitHub() error {
 	fmt.Println("\n=== Teleport Setup (GitHub SSO) ===")
@@ -26,18 +56,12 @@ func setupTeleportGitHub() error {
 
 	// Not logged in, initiate login
 	fmt.Println("You are not logged in to Teleport")
-	fmt.Printf("Logging in to %s using GitHub SSO...\n", teleportProxy)
+	cluster := cfg.activeCluster()
+	fmt.Printf("Logging in to %s using GitHub SSO...\n", cluster.Proxy)
 	fmt.Println()
 
 	// Run tsh login interactively with GitHub auth
-	cmd := exec.Command("tsh", "login",
-		fmt.Sprintf("--proxy=%s", teleportProxy),
-		fmt.Sprintf("--auth=%s", githubAuth))
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
+	if err := tshClient.Login(cluster.Proxy, cluster.AuthConnector); err != nil {
 		return fmt.Errorf("failed to log in: %v", err)
 	}
 
@@ -73,19 +97,13 @@ func setupTeleportLocal() error {
 
 	// Not logged in, initiate login
 	fmt.Println("You are not logged in to Teleport")
-	fmt.Printf("Logging in to %s using local account...\n", teleportProxy)
-	fmt.Println("You will be prompted for your username and password.\n")
+	cluster := cfg.activeCluster()
+	fmt.Printf("Logging in to %s using local account...\n", cluster.Proxy)
+	fmt.Println("You will be prompted for your username and password.")
 	fmt.Println()
 
-	// Run tsh login interactively without auth connector (uses default local auth)
-	cmd := exec.Command("tsh", "login",
-		fmt.Sprintf("--proxy=%s", teleportProxy),
-		"--auth=local")
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
+	// Run tsh login interactively with the local auth connector
+	if err := tshClient.Login(cluster.Proxy, "local"); err != nil {
 		return fmt.Errorf("failed to log in: %v", err)
 	}
 
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// spinnerFrames are drawn in turn while a spinner runs
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// spinner shows a status line with an animated frame on a terminal. Other
+// output goes through println so it isn't mixed with the status line. When
+// stdout isn't a terminal only the println output is written.
+type spinner struct {
+	mu      sync.Mutex
+	message string
+	frame   int
+	tty     bool
+	ended   bool
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// startSpinner starts a spinner showing message
+func startSpinner(message string) *spinner {
+	s := &spinner{
+		message: message,
+		tty:     term.IsTerminal(int(os.Stdout.Fd())),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if !s.tty {
+		close(s.stopped)
+		return s
+	}
+
+	go func() {
+		defer close(s.stopped)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			s.mu.Lock()
+			s.draw()
+			s.mu.Unlock()
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return s
+}
+
+// draw redraws the status line; s.mu must be held
+func (s *spinner) draw() {
+	fmt.Printf("\r\033[K%s %s", spinnerFrames[s.frame%len(spinnerFrames)], s.message)
+	s.frame++
+}
+
+// update replaces the status message
+func (s *spinner) update(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.message = message
+}
+
+// println prints a line above the status line
+func (s *spinner) println(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tty {
+		fmt.Print("\r\033[K")
+	}
+	fmt.Println(line)
+	if s.tty && !s.ended {
+		s.draw()
+	}
+}
+
+// stop removes the status line
+func (s *spinner) stop() {
+	select {
+	case <-s.done:
+		return
+	default:
+		close(s.done)
+	}
+	<-s.stopped
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+	if s.tty {
+		fmt.Print("\r\033[K")
+	}
+}
@@ -1,13 +1,107 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"time"
 
 	"github.com/AlecAivazis/survey/v2"
+	"github.com/spf13/cobra"
 )
 
+var (
+	sshLogin   string
+	sshReprobe bool
+)
+
+// reprobeOption is the login picker entry that tests the logins again
+const reprobeOption = "↻ Test the logins again"
+
+var sshCmd = &cobra.Command{
+	Use:   "ssh <node> [--login user] [-- command...]",
+	Short: "Connect to a Teleport node",
+	Long: `Connect to a Teleport node without going through the interactive menu.
+
+If --login is not given, the login comes from node_logins, the last login that
+worked on the node, or login_rules. Failing those, the logins available on the
+node are tested (or taken from the results cached for your current
+certificate) and the best default from login_priority (ubuntu > root by
+default) is used. Anything after the node name is run as a remote command
+instead of opening a shell.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireTsh(); err != nil {
+			return err
+		}
+
+		if !isTeleportLoggedIn() {
+			return fmt.Errorf("not logged in to Teleport, run 'scicom-helper login' first")
+		}
+
+		node := args[0]
+		login := sshLogin
+		if login == "" {
+			login = configuredNodeLogin(node)
+		}
+		allowed := false
+		if login == "" {
+			probes, err := getNodeLogins(node, sshReprobe)
+			if err != nil {
+				return fmt.Errorf("failed to get logins: %v", err)
+			}
+			logins := usableLogins(probes)
+			if len(logins) == 0 {
+				return probeFailure(node, probes)
+			}
+			login = pickDefaultLogin(logins)
+			allowed = probedAllowed(probes, login)
+		}
+
+		err := connectToNode(node, login, args[1:], allowed)
+
+		// Propagate the remote exit code so scripts can rely on it
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(args) > 1 {
+			os.Exit(exitErr.ExitCode())
+		}
+		return err
+	},
+}
+
+func init() {
+	sshCmd.Flags().StringVarP(&sshLogin, "login", "l", "", "login user on the node (default: best available login)")
+	sshCmd.Flags().BoolVar(&sshReprobe, "reprobe", false, "test the logins on the node again instead of using cached results")
+	rootCmd.AddCommand(sshCmd)
+}
+
+// configuredNodeLogin returns the login node_logins, the last successful
+// session or login_rules choose for node, or "" if none applies
+func configuredNodeLogin(node string) string {
+	last, err := loadLastLogins()
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+
+	target := tshNode{Hostname: node}
+	if len(cfg.LoginRules) > 0 {
+		// Rules need the node's labels
+		if nodes, err := getTeleportNodes(); err == nil {
+			for _, n := range nodes {
+				if n.Hostname == node {
+					target = n
+					break
+				}
+			}
+		}
+	}
+
+	login, _ := resolveNodeLogin(cfg.activeCluster().Name, target, last, "")
+	return login
+}
+
 // sshToNode allows user to select a node and SSH into it
 func sshToNode() error {
 	fmt.Println("\n=== Teleport SSH ===")
@@ -35,65 +129,168 @@ func sshToNode() error {
 	fmt.Printf("Found %d node(s)\n\n", len(nodes))
 
 	// Let user select a node
-	var selectedNode string
-	prompt := &survey.Select{
-		Message: "Select a node to connect to:",
-		Options: nodes,
-		PageSize: 15,
-	}
-
-	err = survey.AskOne(prompt, &selectedNode)
+	node, err := pickNode(nodes)
 	if err != nil {
-		return fmt.Errorf("selection cancelled")
+		return err
 	}
+	selectedNode := node.Hostname
 
 	// Get available logins for the selected node
-	fmt.Printf("\nTesting available logins for %s...\n", selectedNode)
-	fmt.Println("(This may take a few seconds)")
-	logins, err := getNodeLogins(selectedNode)
+	fmt.Println()
+	selectedLogin, allowed, err := selectNodeLogin(selectedNode)
 	if err != nil {
-		return fmt.Errorf("failed to get logins: %v", err)
+		return err
+	}
+
+	return connectToNode(selectedNode, selectedLogin, nil, allowed)
+}
+
+// selectNodeLogin asks which login to use on node, listing every login with
+// its probe result. Picking reprobeOption tests the logins again. It also
+// reports whether the login was probed as allowed.
+func selectNodeLogin(node string) (string, bool, error) {
+	reprobe := false
+	for {
+		probes, err := getNodeLogins(node, reprobe)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to get logins: %v", err)
+		}
+		login, again, err := askNodeLogin(node, probes)
+		if err != nil || !again {
+			return login, probedAllowed(probes, login), err
+		}
+		reprobe = true
+		fmt.Println()
 	}
+}
 
-	if len(logins) == 0 {
-		return fmt.Errorf("no logins available for this node")
+// askNodeLogin shows the login picker for probes. It reports again when the
+// user asks to test the logins again.
+func askNodeLogin(selectedNode string, probes []loginProbe) (string, bool, error) {
+	cached := false
+	for _, probe := range probes {
+		cached = cached || !probe.cached.IsZero()
 	}
 
-	// Let user select a login
-	var selectedLogin string
+	// Cached results may be stale, so only give up on fresh ones
+	logins := usableLogins(probes)
+	if len(logins) == 0 && !cached {
+		return "", false, probeFailure(selectedNode, probes)
+	}
+
+	// Default to the node's configured or last working login if the node
+	// allows it, otherwise the best login from login_priority
+	var defaultLogin string
+	if len(logins) > 0 {
+		defaultLogin = pickDefaultLogin(logins)
+	}
+	if login := configuredNodeLogin(selectedNode); login != "" {
+		for _, available := range logins {
+			if available == login {
+				defaultLogin = login
+				break
+			}
+		}
+	}
+
+	// Show every login with its probe result, so it's clear why some
+	// can't be used
+	options := make([]string, len(probes))
+	defaultOption := reprobeOption
+	for i, probe := range probes {
+		options[i] = probe.String()
+		if probe.login == defaultLogin {
+			defaultOption = options[i]
+		}
+	}
+	options = append(options, reprobeOption)
+
+	var selectedOption string
 	loginPrompt := &survey.Select{
 		Message: "Select a login user:",
-		Options: logins,
-		Default: logins[0], // Default to first login (usually ubuntu)
+		Options: options,
+		Default: defaultOption,
 	}
 
-	err = survey.AskOne(loginPrompt, &selectedLogin)
-	if err != nil {
-		return fmt.Errorf("selection cancelled")
+	if err := survey.AskOne(loginPrompt, &selectedOption); err != nil {
+		return "", false, fmt.Errorf("selection cancelled")
+	}
+	if selectedOption == reprobeOption {
+		return "", true, nil
 	}
+	for i, option := range options[:len(probes)] {
+		if option == selectedOption {
+			return probes[i].login, false, nil
+		}
+	}
+	return "", false, fmt.Errorf("selection cancelled")
+}
 
-	// Connect to the selected node with the selected login
-	fmt.Printf("\nConnecting to %s as %s...\n", selectedNode, selectedLogin)
-	fmt.Println("(Press Ctrl+D or type 'exit' to disconnect)")
-	fmt.Println()
+// connectToNode opens an SSH session to the node as the given login.
+// If command is non-empty it is run on the node instead of an interactive shell.
+// allowed tells whether the login was probed as allowed on the node.
+func connectToNode(node, login string, command []string, allowed bool) error {
+	interactive := len(command) == 0
 
-	// Run tsh ssh interactively with login user
-	cmd := exec.Command("tsh", "ssh", fmt.Sprintf("%s@%s", selectedLogin, selectedNode))
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	if interactive {
+		fmt.Printf("\nConnecting to %s as %s...\n", node, login)
+		fmt.Println("(Press Ctrl+D or type 'exit' to disconnect)")
+		fmt.Println()
+	}
+
+	// Run tsh ssh with login user
+	err := tshClient.SSH(context.Background(), sshSession{
+		Proxy:   cfg.activeCluster().Proxy,
+		Target:  fmt.Sprintf("%s@%s", login, node),
+		Command: command,
+		Stdin:   os.Stdin,
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+	})
+
+	exitCode := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		exitCode = -1
+	}
+	// tsh exits with 1 both when the login is denied and when the remote
+	// command fails, so a failed session only counts if the login is known
+	// to work. Ctrl+C ends an interactive session with 130.
+	connected := err == nil || interactive && exitCode == 130 || allowed && exitErr != nil && exitCode != 255
+	recordSession(node, login, exitCode, connected)
 
-	if err := cmd.Run(); err != nil {
+	if err != nil {
+		if !interactive {
+			return err
+		}
 		// Don't treat normal exit as an error
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			if exitErr.ExitCode() == 130 { // Ctrl+C
-				fmt.Println("\nConnection closed")
-				return nil
-			}
+		if exitCode == 130 { // Ctrl+C
+			fmt.Println("\nConnection closed")
+			return nil
 		}
 		return fmt.Errorf("SSH connection failed: %v", err)
 	}
 
-	fmt.Println("\nConnection closed")
+	if interactive {
+		fmt.Println("\nConnection closed")
+	}
 	return nil
 }
+
+// recordSession adds the session to the connection history, which feeds the
+// recent nodes in the picker and the default login of the node
+func recordSession(node, login string, exitCode int, connected bool) {
+	err := recordConnection(historyEntry{
+		Cluster:   cfg.activeCluster().Name,
+		Node:      node,
+		Login:     login,
+		Time:      time.Now(),
+		ExitCode:  exitCode,
+		Connected: connected,
+	})
+	if err != nil {
+		fmt.Printf("Warning: failed to record connection history: %v\n", err)
+	}
+}
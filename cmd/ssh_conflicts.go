@@ -0,0 +1,510 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+// conflictKeywords are the directives of generated hosts that other blocks
+// must not change. For each of them the first value wins, so a later block
+// only conflicts where the generated hosts leave the option unset.
+// IdentityFile and CertificateFile are not checked: they accumulate, and the
+// Teleport key comes first since the Include is at the top of ~/.ssh/config.
+var conflictKeywords = map[string]bool{
+	"hostname":     true,
+	"user":         true,
+	"port":         true,
+	"proxycommand": true,
+	"proxyjump":    true,
+	"hostkeyalias": true,
+}
+
+// unrelatedHost is a name no generated host has, for telling blocks that
+// also apply to the user's other hosts (such as Host *) from Teleport ones
+const unrelatedHost = "unrelated-host.scicom-helper.invalid"
+
+// maxEffectiveChecks limits how many hosts are checked with ssh -G
+const maxEffectiveChecks = 20
+
+// sshConflict is a directive outside our block that changes the effective
+// configuration of generated hosts
+type sshConflict struct {
+	line    sshLine // the directive; zero if only ssh -G saw the difference
+	block   string  // header of the block holding it, e.g. "Host *"
+	keyword string
+	aliases []string
+	detail  string // what ssh -G reported, if it found the conflict
+	// global is set if the block also applies to hosts outside Teleport, so
+	// the line must not be commented out
+	global bool
+	header *sshLine // header of the block, nil at the top of the file
+}
+
+// String describes the conflict for the user
+func (c sshConflict) String() string {
+	hosts := strings.Join(c.aliases, ", ")
+	if len(c.aliases) > 5 {
+		hosts = strings.Join(c.aliases[:5], ", ") + fmt.Sprintf(" and %d more", len(c.aliases)-5)
+	}
+	if c.line.num == 0 {
+		return fmt.Sprintf("%s for %s (set outside ~/.ssh/config)", c.detail, hosts)
+	}
+	return fmt.Sprintf("line %d (%s): %s applies to %s", c.line.num, c.block, c.line.text(), hosts)
+}
+
+// matchSSHPattern matches host against an OpenSSH pattern with * and ? wildcards
+func matchSSHPattern(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(host); i >= 0; i-- {
+				if matchSSHPattern(pattern[1:], host[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if host == "" {
+				return false
+			}
+		default:
+			if host == "" || host[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, host = pattern[1:], host[1:]
+	}
+	return host == ""
+}
+
+// matchSSHPatternList applies a pattern list: any negated match excludes
+// the host, otherwise one positive match is needed
+func matchSSHPatternList(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			if matchSSHPattern(pattern[1:], host) {
+				return false
+			}
+		} else if matchSSHPattern(pattern, host) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// appliesTo reports whether the block applies to a connection to alias,
+// whose HostName so far is hostname. Host and Match originalhost match the
+// alias, Match host matches the HostName. Match criteria other than all, host
+// and originalhost can't be evaluated and count as not matching.
+func (b *sshBlock) appliesTo(alias, hostname string) bool {
+	if b.header == nil {
+		return true
+	}
+	if b.header.keyword == "host" {
+		return matchSSHPatternList(b.header.args, alias)
+	}
+
+	args := b.header.args
+	for i := 0; i < len(args); i++ {
+		criterion := strings.ToLower(args[i])
+		negate := strings.HasPrefix(criterion, "!")
+		criterion = strings.TrimPrefix(criterion, "!")
+
+		var matched bool
+		switch criterion {
+		case "all":
+			matched = true
+		case "host", "originalhost":
+			if i+1 >= len(args) {
+				return false
+			}
+			i++
+			host := alias
+			if criterion == "host" && hostname != "" {
+				host = hostname
+			}
+			matched = matchSSHPatternList(strings.Split(args[i], ","), host)
+		default:
+			return false
+		}
+		if matched == negate {
+			return false
+		}
+	}
+	return true
+}
+
+// blockName describes a block for messages
+func blockName(b *sshBlock) string {
+	if b.header == nil {
+		return "top of file"
+	}
+	return b.header.text()
+}
+
+// sshBlockIndex holds the generated blocks for evaluating many aliases.
+// Host blocks naming only literal hosts are looked up by name instead of
+// being matched against every alias, which matters with thousands of nodes.
+type sshBlockIndex struct {
+	blocks  []*sshBlock
+	generic []int            // blocks that have to be matched
+	literal map[string][]int // blocks by the one host they name
+}
+
+// newSSHBlockIndex indexes the blocks of the generated sections
+func newSSHBlockIndex(generated []*sshSection) *sshBlockIndex {
+	index := &sshBlockIndex{literal: map[string][]int{}}
+	for _, section := range generated {
+		for _, block := range section.blocks {
+			i := len(index.blocks)
+			index.blocks = append(index.blocks, block)
+			patterns := block.patterns()
+			literal := len(patterns) > 0
+			for _, pattern := range patterns {
+				literal = literal && !strings.ContainsAny(pattern, "*?!")
+			}
+			if !literal {
+				index.generic = append(index.generic, i)
+				continue
+			}
+			for _, pattern := range patterns {
+				name := strings.ToLower(pattern)
+				index.literal[name] = append(index.literal[name], i)
+			}
+		}
+	}
+	return index
+}
+
+// options evaluates the generated blocks for alias and returns the first
+// value of each option, with %h in HostName expanded
+func (index *sshBlockIndex) options(alias string) map[string]string {
+	// Merge the alias's own blocks back into file order
+	literal := index.literal[strings.ToLower(alias)]
+	order := make([]int, 0, len(index.generic)+len(literal))
+	g, l := 0, 0
+	for g < len(index.generic) || l < len(literal) {
+		if l == len(literal) || (g < len(index.generic) && index.generic[g] < literal[l]) {
+			order = append(order, index.generic[g])
+			g++
+		} else {
+			order = append(order, literal[l])
+			l++
+		}
+	}
+
+	options := map[string]string{}
+	for _, i := range order {
+		block := index.blocks[i]
+		if !block.appliesTo(alias, options["hostname"]) {
+			continue
+		}
+		for _, line := range block.lines {
+			if _, ok := options[line.keyword]; !ok && line.keyword != "" {
+				options[line.keyword] = strings.Join(line.args, " ")
+				if line.keyword == "hostname" {
+					options[line.keyword] = strings.ReplaceAll(options[line.keyword], "%h", alias)
+				}
+			}
+		}
+	}
+	return options
+}
+
+// findSSHConflicts evaluates the generated blocks followed by the user's
+// config for each alias, the way OpenSSH reads them, and returns the user
+// directives that change a conflictKeywords setting of a generated host
+func findSSHConflicts(generated []*sshSection, user *sshConfigFile, aliases []string) []sshConflict {
+	index := newSSHBlockIndex(generated)
+	byLine := map[int]*sshConflict{}
+	for _, alias := range aliases {
+		options := index.options(alias)
+		set := map[string]bool{}
+		for keyword := range options {
+			set[keyword] = true
+		}
+		hostname := options["hostname"]
+
+		for _, item := range user.items {
+			block := item.block
+			if block == nil || !block.appliesTo(alias, hostname) {
+				continue
+			}
+			for _, line := range block.lines {
+				if !conflictKeywords[line.keyword] {
+					continue
+				}
+				// ProxyCommand and ProxyJump exclude each other, the first one wins
+				key := line.keyword
+				if key == "proxyjump" {
+					key = "proxycommand"
+				}
+				if set[key] {
+					continue
+				}
+				set[key] = true
+
+				conflict, ok := byLine[line.num]
+				if !ok {
+					conflict = &sshConflict{
+						line:    line,
+						block:   blockName(block),
+						keyword: line.keyword,
+						global:  block.appliesTo(unrelatedHost, ""),
+						header:  block.header,
+					}
+					byLine[line.num] = conflict
+				}
+				conflict.aliases = append(conflict.aliases, alias)
+			}
+		}
+	}
+
+	var conflicts []sshConflict
+	for _, conflict := range byLine {
+		conflicts = append(conflicts, *conflict)
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].line.num < conflicts[j].line.num
+	})
+	return conflicts
+}
+
+// sshEffectiveConfig runs ssh -G for host against the config at path and
+// returns the first value of every option
+func sshEffectiveConfig(path, host string) (map[string]string, error) {
+	out, err := runCommand("ssh", "-G", "-F", path, host)
+	if err != nil {
+		return nil, err
+	}
+
+	options := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		if _, seen := options[key]; !seen {
+			options[key] = value
+		}
+	}
+	return options, nil
+}
+
+// sameSSHValue compares values of option key ignoring quoting and spacing.
+// Host names are compared ignoring case, since ssh -G lowercases them.
+func sameSSHValue(key, a, b string) bool {
+	normalize := func(v string) string {
+		return strings.Join(strings.Fields(strings.ReplaceAll(v, `"`, "")), " ")
+	}
+	if key == "hostname" {
+		return strings.EqualFold(normalize(a), normalize(b))
+	}
+	return normalize(a) == normalize(b)
+}
+
+// checkEffectiveConfig asks ssh -G how each alias resolves with the new
+// configuration and reports options that differ from the generated block
+// without a directive in ~/.ssh/config to explain them
+func checkEffectiveConfig(generated []*sshSection, user *sshConfigFile, aliases []string, known []sshConflict) []sshConflict {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		return nil
+	}
+
+	// Inline the generated blocks in place of the Include
+	var content strings.Builder
+	for _, section := range generated {
+		content.WriteString(section.String())
+	}
+	for _, item := range user.items {
+		if item.block == nil {
+			continue
+		}
+		if item.block.header != nil {
+			content.WriteString(item.block.header.raw)
+		}
+		for _, line := range item.block.lines {
+			if !isIncludeLine(line) {
+				content.WriteString(line.raw)
+			}
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "scicom-helper-ssh-config-*")
+	if err != nil {
+		return nil
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(content.String())
+	tmp.Close()
+	if err != nil {
+		return nil
+	}
+
+	explained := map[string]bool{}
+	for _, conflict := range known {
+		key := conflict.keyword
+		if key == "proxyjump" {
+			key = "proxycommand"
+		}
+		for _, alias := range conflict.aliases {
+			explained[alias+" "+key] = true
+		}
+	}
+
+	// Generated hosts differ only in HostName and User, so an evenly spaced
+	// sample is enough and keeps large clusters fast
+	sample := aliases
+	if len(aliases) > maxEffectiveChecks {
+		sample = nil
+		for i := 0; i < maxEffectiveChecks; i++ {
+			sample = append(sample, aliases[i*len(aliases)/maxEffectiveChecks])
+		}
+	}
+
+	index := newSSHBlockIndex(generated)
+	byDetail := map[string]*sshConflict{}
+	var order []string
+	for _, alias := range sample {
+		want := index.options(alias)
+
+		got, err := sshEffectiveConfig(tmp.Name(), alias)
+		if err != nil {
+			continue
+		}
+		for _, key := range []string{"hostname", "user", "port", "proxycommand"} {
+			expected, ok := want[key]
+			if !ok || explained[alias+" "+key] || sameSSHValue(key, got[key], expected) {
+				continue
+			}
+			detail := fmt.Sprintf("ssh -G resolves %s to %q instead of %q", key, got[key], expected)
+			if got[key] == "" {
+				detail = fmt.Sprintf("ssh -G drops %s %q", key, expected)
+			}
+			conflict, ok := byDetail[detail]
+			if !ok {
+				conflict = &sshConflict{keyword: key, detail: detail}
+				byDetail[detail] = conflict
+				order = append(order, detail)
+			}
+			conflict.aliases = append(conflict.aliases, alias)
+		}
+	}
+
+	var conflicts []sshConflict
+	for _, detail := range order {
+		conflicts = append(conflicts, *byDetail[detail])
+	}
+	return conflicts
+}
+
+// scopedHeader returns a Match header for block that keeps it for every host
+// it matched except the hosts of proxies, or "" for lines at the top of the file
+func scopedHeader(header *sshLine, proxies []string) string {
+	if header == nil {
+		return ""
+	}
+	var hosts []string
+	for _, proxy := range proxies {
+		hosts = append(hosts, "*."+proxy)
+	}
+	exclude := "!host " + strings.Join(hosts, ",")
+
+	switch {
+	case header.keyword == "host":
+		return fmt.Sprintf("Match originalhost %s %s", strings.Join(header.args, ","), exclude)
+	case len(header.args) == 1 && strings.EqualFold(header.args[0], "all"):
+		return "Match " + exclude
+	default:
+		return fmt.Sprintf("Match %s %s", strings.Join(header.args, " "), exclude)
+	}
+}
+
+// resolveSSHConflicts reports conflicts in ~/.ssh/config. Lines that only
+// apply to Teleport hosts are commented out, when picked or with
+// opts.fixConflicts (--fix-conflicts). Blocks that also apply to other hosts,
+// such as Host *, are never commented out: when picked, their header is
+// rewritten to exclude the hosts of proxies instead.
+func resolveSSHConflicts(user *sshConfigFile, conflicts []sshConflict, proxies []string, opts writeOptions) error {
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	fmt.Println()
+	fmt.Printf("Warning: %d setting(s) in ~/.ssh/config change the generated Teleport hosts:\n", len(conflicts))
+	var fixable, global []sshConflict
+	scoped := map[int]bool{}
+	for _, conflict := range conflicts {
+		fmt.Printf("  %s\n", conflict)
+		switch {
+		case conflict.line.num == 0:
+		case !conflict.global:
+			fixable = append(fixable, conflict)
+		case conflict.header != nil && !scoped[conflict.header.num]:
+			// One rewrite per block, however many of its lines conflict
+			scoped[conflict.header.num] = true
+			global = append(global, conflict)
+		}
+	}
+	fmt.Println()
+
+	for _, conflict := range global {
+		fmt.Printf("Line %d applies to other hosts too and is kept. To keep it away from Teleport hosts,\n", conflict.line.num)
+		fmt.Printf("replace \"%s\" (line %d) with \"%s\"\n", conflict.header.text(), conflict.header.num, scopedHeader(conflict.header, proxies))
+	}
+	if len(global) > 0 {
+		fmt.Println()
+	}
+	if len(fixable) == 0 && (len(global) == 0 || !opts.confirm) {
+		return nil
+	}
+
+	var fix, scope []sshConflict
+	switch {
+	case opts.confirm:
+		var options []string
+		for _, conflict := range fixable {
+			options = append(options, fmt.Sprintf("line %d: comment out %s", conflict.line.num, conflict.line.text()))
+		}
+		for _, conflict := range global {
+			options = append(options, fmt.Sprintf("line %d: change %q to %q", conflict.header.num, conflict.header.text(), scopedHeader(conflict.header, proxies)))
+		}
+		var picked []int
+		prompt := &survey.MultiSelect{
+			Message: "Fix these settings? (a changed header also stops its other settings applying to Teleport hosts)",
+			Options: options,
+		}
+		if err := survey.AskOne(prompt, &picked); err != nil {
+			return nil
+		}
+		for _, i := range picked {
+			if i < len(fixable) {
+				fix = append(fix, fixable[i])
+			} else {
+				scope = append(scope, global[i-len(fixable)])
+			}
+		}
+	case opts.fixConflicts:
+		fix = fixable
+	default:
+		fmt.Println("Run 'scicom-helper update-nodes --fix-conflicts' to comment these lines out,")
+		fmt.Println("or move them under a Host block that doesn't match the Teleport hosts.")
+		fmt.Println()
+		return nil
+	}
+
+	for _, conflict := range fix {
+		user.commentOut(conflict.line.num, "disabled by scicom-helper, conflicts with Teleport hosts")
+	}
+	for _, conflict := range scope {
+		user.replaceHeader(conflict.header.num, scopedHeader(conflict.header, proxies), "scoped by scicom-helper to exclude Teleport hosts")
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// testGeneratedSections returns the generated section of testClusterState
+func testGeneratedSections(t *testing.T, names ...string) []*sshSection {
+	t.Helper()
+	home := useTestEnv(t, &fakeTsh{})
+	var aliases []nodeAlias
+	for _, name := range names {
+		aliases = append(aliases, nodeAlias{Alias: name, Hostname: name})
+	}
+	return []*sshSection{buildTeleportSection(home, testClusterState(names...), aliases)}
+}
+
+func TestFindSSHConflicts(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       string
+		wantLines  []int
+		wantGlobal []bool
+	}{
+		{
+			name:      "global identity file",
+			user:      "Host *\n    IdentityFile ~/.ssh/id_ed25519\n",
+			wantLines: nil,
+		},
+		{
+			name:      "global user loses to the generated one",
+			user:      "Host *\n    User git\n",
+			wantLines: nil,
+		},
+		{
+			name:       "global host key alias",
+			user:       "Host *\n    HostKeyAlias shared\n",
+			wantLines:  []int{2},
+			wantGlobal: []bool{true},
+		},
+		{
+			name:       "host key alias for Teleport hosts only",
+			user:       "Host web-*\n    HostKeyAlias shared\n",
+			wantLines:  []int{2},
+			wantGlobal: []bool{false},
+		},
+		{
+			name:       "top of file",
+			user:       "HostKeyAlias shared\nHost github.com\n    User git\n",
+			wantLines:  []int{1},
+			wantGlobal: []bool{true},
+		},
+		{
+			name:      "other hosts only",
+			user:      "Host github.com\n    HostKeyAlias github\n",
+			wantLines: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generated := testGeneratedSections(t, "web-1")
+			conflicts := findSSHConflicts(generated, parseSSHConfig(tt.user), []string{"web-1"})
+
+			if len(conflicts) != len(tt.wantLines) {
+				t.Fatalf("got %d conflict(s) %v, want lines %v", len(conflicts), conflicts, tt.wantLines)
+			}
+			for i, conflict := range conflicts {
+				if conflict.line.num != tt.wantLines[i] || conflict.global != tt.wantGlobal[i] {
+					t.Errorf("conflict %d: line %d global %v, want line %d global %v", i, conflict.line.num, conflict.global, tt.wantLines[i], tt.wantGlobal[i])
+				}
+			}
+		})
+	}
+}
+
+func TestResolveSSHConflictsKeepsGlobalLines(t *testing.T) {
+	generated := testGeneratedSections(t, "web-1", "web-2")
+	user := parseSSHConfig("Host web-1\n    HostKeyAlias web\n\nMatch all\n    HostKeyAlias shared\n")
+	conflicts := findSSHConflicts(generated, user, []string{"web-1", "web-2"})
+	if len(conflicts) != 2 {
+		t.Fatalf("got %d conflict(s), want 2: %v", len(conflicts), conflicts)
+	}
+
+	if err := resolveSSHConflicts(user, conflicts, []string{testProxy}, writeOptions{fixConflicts: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := user.String()
+	want := "Host web-1\n    # HostKeyAlias web (disabled by scicom-helper, conflicts with Teleport hosts)\n\nMatch all\n    HostKeyAlias shared\n"
+	if got != want {
+		t.Errorf("resolveSSHConflicts() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestScopedHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"Host *", "Match originalhost * !host *.a.example.com,*.b.example.com"},
+		{"Host * !bastion", "Match originalhost *,!bastion !host *.a.example.com,*.b.example.com"},
+		{"Match all", "Match !host *.a.example.com,*.b.example.com"},
+		{"Match user root", "Match user root !host *.a.example.com,*.b.example.com"},
+	}
+	for _, tt := range tests {
+		header := parseSSHLine(tt.header+"\n", 1)
+		if got := scopedHeader(&header, []string{"a.example.com", "b.example.com"}); got != tt.want {
+			t.Errorf("scopedHeader(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+	if got := scopedHeader(nil, []string{"a.example.com"}); got != "" {
+		t.Errorf("scopedHeader(nil) = %q, want empty", got)
+	}
+}
+
+func TestReplaceHeader(t *testing.T) {
+	user := parseSSHConfig("Host github.com\n    User git\n\nHost *\n    HostKeyAlias shared\n")
+	if !user.replaceHeader(4, "Match originalhost * !host *.teleport.example.com", "scoped") {
+		t.Fatal("header on line 4 not found")
+	}
+	got := user.String()
+	want := "Host github.com\n    User git\n\nMatch originalhost * !host *.teleport.example.com\n    # was: Host * (scoped)\n    HostKeyAlias shared\n"
+	if got != want {
+		t.Errorf("replaceHeader() =\n%s\nwant\n%s", got, want)
+	}
+
+	// The rewritten block no longer applies to Teleport hosts
+	generated := testGeneratedSections(t, "web-1")
+	if conflicts := findSSHConflicts(generated, parseSSHConfig(got), []string{"web-1"}); len(conflicts) != 0 {
+		t.Errorf("scoped block still conflicts: %v", conflicts)
+	}
+	if !strings.Contains(got, "HostKeyAlias shared") {
+		t.Error("scoping dropped the directive")
+	}
+}
+
+func TestSameSSHValue(t *testing.T) {
+	tests := []struct {
+		key, a, b string
+		want      bool
+	}{
+		{"hostname", "gpu-a100.teleport.example.com", "GPU-A100.teleport.example.com", true},
+		{"user", "Ubuntu", "ubuntu", false},
+		{"proxycommand", `"tsh" proxy ssh  %r@%h:%p`, "tsh proxy ssh %r@%h:%p", true},
+		{"port", "3022", "22", false},
+	}
+	for _, tt := range tests {
+		if got := sameSSHValue(tt.key, tt.a, tt.b); got != tt.want {
+			t.Errorf("sameSSHValue(%q, %q, %q) = %v, want %v", tt.key, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCheckEffectiveConfigUppercaseNode(t *testing.T) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("ssh not installed")
+	}
+	generated := testGeneratedSections(t, "GPU-Node-1")
+	if conflicts := checkEffectiveConfig(generated, parseSSHConfig(""), []string{"GPU-Node-1"}, nil); len(conflicts) != 0 {
+		t.Errorf("uppercase node name reported as a conflict: %v", conflicts)
+	}
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+const (
+	// includeRelPath is the generated file, relative to ~/.ssh as OpenSSH resolves it
+	includeRelPath = "config.d/scicom-helper.conf"
+	includeComment = "# Added by scicom-helper: Teleport hosts, refreshed by 'scicom-helper update-nodes'"
+	includeHeader  = "# Generated by scicom-helper. Do not edit: this file is overwritten by\n# 'scicom-helper update-nodes'. Put your own settings in ~/.ssh/config.\n"
+)
+
+// sshIncludePath returns the absolute path of the generated SSH config file
+func sshIncludePath(sshDir string) string {
+	return filepath.Join(sshDir, filepath.FromSlash(includeRelPath))
+}
+
+// isIncludeLine reports whether line is our managed Include directive or its comment
+func isIncludeLine(line sshLine) bool {
+	if line.text() == includeComment {
+		return true
+	}
+	if line.keyword != "include" || len(line.args) != 1 {
+		return false
+	}
+	target := line.args[0]
+	return target == includeRelPath || target == "~/.ssh/"+includeRelPath
+}
+
+// ensureInclude puts our Include directive first in file, so the generated
+// hosts take precedence over anything the user declares, including later
+// Host * stanzas
+func ensureInclude(file *sshConfigFile) {
+	file.removeLines(isIncludeLine)
+	file.trimLeadingBlank()
+
+	include := fmt.Sprintf("%s\nInclude %s\n", includeComment, includeRelPath)
+	if len(file.items) > 0 {
+		include += "\n"
+	}
+	file.prepend(include)
+}
@@ -0,0 +1,538 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sshLine is one line of an SSH config file. raw keeps the line exactly as
+// read, including its line ending, so lines we don't touch are written back
+// byte for byte.
+type sshLine struct {
+	raw     string
+	num     int    // line number in the parsed file, 0 for generated lines
+	keyword string // lower-cased directive keyword, empty for comments and blank lines
+	args    []string
+}
+
+// text returns the line without surrounding whitespace or its line ending
+func (l sshLine) text() string {
+	return strings.TrimSpace(l.raw)
+}
+
+// isComment reports whether the line is a comment
+func (l sshLine) isComment() bool {
+	return strings.HasPrefix(l.text(), "#")
+}
+
+// isBlank reports whether the line is empty or whitespace only
+func (l sshLine) isBlank() bool {
+	return l.text() == ""
+}
+
+// sshBlock is a Host or Match block and the lines following it. A block
+// without a header holds lines before the first Host or Match, which apply
+// to every host.
+type sshBlock struct {
+	header *sshLine
+	lines  []sshLine
+}
+
+// patterns returns the patterns of a Host block
+func (b *sshBlock) patterns() []string {
+	if b.header == nil || b.header.keyword != "host" {
+		return nil
+	}
+	return b.header.args
+}
+
+// sshSection is a block of the SSH config managed by scicom-helper,
+// delimited by marker comments
+type sshSection struct {
+	name   string // cluster name, empty for the unnamed block of older versions
+	start  sshLine
+	end    sshLine // zero if the END marker is missing
+	blocks []*sshBlock
+}
+
+// sshItem is a top-level element of an SSH config file: a block of user
+// configuration or a managed section
+type sshItem struct {
+	block   *sshBlock
+	section *sshSection
+}
+
+// sshConfigProblem describes something in an SSH config we can't safely
+// rewrite (fatal) or that the user should know about
+type sshConfigProblem struct {
+	line    int
+	message string
+	fatal   bool
+}
+
+func (p sshConfigProblem) String() string {
+	return fmt.Sprintf("line %d: %s", p.line, p.message)
+}
+
+// sshConfigFile is a parsed OpenSSH client config
+type sshConfigFile struct {
+	items    []sshItem
+	problems []sshConfigProblem
+}
+
+// parseSSHLine splits a config line into its keyword and arguments.
+// Keywords may be separated from their arguments by whitespace or "=".
+func parseSSHLine(raw string, num int) sshLine {
+	line := sshLine{raw: raw, num: num}
+	text := line.text()
+	if text == "" || strings.HasPrefix(text, "#") {
+		return line
+	}
+
+	end := strings.IndexAny(text, " \t=")
+	if end == -1 {
+		line.keyword = strings.ToLower(text)
+		return line
+	}
+	line.keyword = strings.ToLower(text[:end])
+
+	rest := strings.TrimLeft(text[end:], " \t")
+	if strings.HasPrefix(rest, "=") {
+		rest = strings.TrimLeft(rest[1:], " \t")
+	}
+	line.args = splitSSHArgs(rest)
+	return line
+}
+
+// splitSSHArgs splits directive arguments on whitespace, honouring double quotes
+func splitSSHArgs(s string) []string {
+	var args []string
+	var current strings.Builder
+	inQuotes, hasArg := false, false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && inQuotes && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\'):
+			i++
+			current.WriteByte(s[i])
+		case c == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case (c == ' ' || c == '\t') && !inQuotes:
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
+			}
+		default:
+			current.WriteByte(c)
+			hasArg = true
+		}
+	}
+	if hasArg {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// parseMarker reports whether line is a start or end marker and the cluster name in it
+func parseMarker(line sshLine, marker string) (string, bool) {
+	if !line.isComment() || !strings.HasPrefix(line.text(), marker) {
+		return "", false
+	}
+	name := strings.TrimSpace(strings.TrimPrefix(line.text(), marker))
+	return strings.TrimSuffix(strings.TrimPrefix(name, "("), ")"), true
+}
+
+// appendSSHLine adds line to blocks, starting a new block at Host and Match
+func appendSSHLine(blocks []*sshBlock, line sshLine) []*sshBlock {
+	if line.keyword == "host" || line.keyword == "match" {
+		return append(blocks, &sshBlock{header: &line})
+	}
+	if len(blocks) == 0 {
+		blocks = append(blocks, &sshBlock{})
+	}
+	last := blocks[len(blocks)-1]
+	last.lines = append(last.lines, line)
+	return blocks
+}
+
+// parseSSHConfig parses content into blocks and managed sections.
+// Malformed markers are reported in problems rather than guessed at.
+func parseSSHConfig(content string) *sshConfigFile {
+	file := &sshConfigFile{}
+	var blocks []*sshBlock // blocks of the current top-level run or section
+	var section *sshSection
+	seen := map[string]int{}
+	afterSection := 0 // line number of the last END marker, until a Host or Match follows
+
+	flush := func() {
+		for _, block := range blocks {
+			file.items = append(file.items, sshItem{block: block})
+		}
+		blocks = nil
+	}
+
+	for i, raw := range splitLines(content) {
+		line := parseSSHLine(raw, i+1)
+
+		if name, ok := parseMarker(line, markerStart); ok {
+			if section != nil {
+				file.problems = append(file.problems, sshConfigProblem{
+					line:    section.start.num,
+					message: fmt.Sprintf("scicom-helper block starts again at line %d before its END marker", line.num),
+					fatal:   true,
+				})
+				section.blocks = blocks
+				file.items = append(file.items, sshItem{section: section})
+				blocks = nil
+			}
+			flush()
+			if first, ok := seen[name]; ok {
+				file.problems = append(file.problems, sshConfigProblem{
+					line:    line.num,
+					message: fmt.Sprintf("duplicate scicom-helper block %q (first at line %d); only the last one is kept", name, first),
+				})
+			}
+			seen[name] = line.num
+			section = &sshSection{name: name, start: line}
+			afterSection = 0
+			continue
+		}
+
+		if name, ok := parseMarker(line, markerEnd); ok {
+			if section == nil {
+				file.problems = append(file.problems, sshConfigProblem{
+					line:    line.num,
+					message: "scicom-helper END marker without a matching BEGIN marker",
+					fatal:   true,
+				})
+				blocks = appendSSHLine(blocks, line)
+				continue
+			}
+			if name != section.name {
+				file.problems = append(file.problems, sshConfigProblem{
+					line:    line.num,
+					message: fmt.Sprintf("END marker for %q closes the block %q started at line %d", name, section.name, section.start.num),
+					fatal:   true,
+				})
+			}
+			section.end = line
+			section.blocks = blocks
+			file.items = append(file.items, sshItem{section: section})
+			blocks = nil
+			section = nil
+			afterSection = line.num
+			continue
+		}
+
+		// Directives right after a managed block still belong to its last Host
+		if afterSection > 0 && line.keyword != "" {
+			if line.keyword != "host" && line.keyword != "match" {
+				file.problems = append(file.problems, sshConfigProblem{
+					line:    line.num,
+					message: fmt.Sprintf("%q follows the scicom-helper block ending at line %d; put it under its own Host or above the block", line.text(), afterSection),
+				})
+			}
+			afterSection = 0
+		}
+
+		blocks = appendSSHLine(blocks, line)
+	}
+
+	if section != nil {
+		file.problems = append(file.problems, sshConfigProblem{
+			line:    section.start.num,
+			message: "scicom-helper block has no END marker",
+			fatal:   true,
+		})
+		section.blocks = blocks
+		file.items = append(file.items, sshItem{section: section})
+		blocks = nil
+	}
+	flush()
+
+	return file
+}
+
+// String renders the section
+func (s *sshSection) String() string {
+	var out strings.Builder
+	out.WriteString(s.start.raw)
+	for _, block := range s.blocks {
+		out.WriteString(block.String())
+	}
+	out.WriteString(s.end.raw)
+	return out.String()
+}
+
+// String renders the block
+func (b *sshBlock) String() string {
+	var out strings.Builder
+	if b.header != nil {
+		out.WriteString(b.header.raw)
+	}
+	for _, line := range b.lines {
+		out.WriteString(line.raw)
+	}
+	return out.String()
+}
+
+// String renders the file; unmodified lines are reproduced exactly
+func (f *sshConfigFile) String() string {
+	var out strings.Builder
+	for _, item := range f.items {
+		if item.section != nil {
+			out.WriteString(item.section.String())
+		} else {
+			out.WriteString(item.block.String())
+		}
+	}
+	return out.String()
+}
+
+// fatalProblems returns the problems that make the file unsafe to rewrite
+func (f *sshConfigFile) fatalProblems() []sshConfigProblem {
+	var fatal []sshConfigProblem
+	for _, problem := range f.problems {
+		if problem.fatal {
+			fatal = append(fatal, problem)
+		}
+	}
+	return fatal
+}
+
+// removeSections takes the managed sections out of the file and returns
+// them by cluster name. Blank lines left at the end of the file are dropped.
+func (f *sshConfigFile) removeSections() map[string]*sshSection {
+	sections := map[string]*sshSection{}
+	var kept []sshItem
+	for _, item := range f.items {
+		if item.section != nil {
+			sections[item.section.name] = item.section
+			continue
+		}
+		kept = append(kept, item)
+	}
+	if len(sections) == 0 {
+		return sections
+	}
+	f.items = kept
+
+	// Removing a trailing block would leave the blank line that separated it
+	f.trimTrailingBlank()
+	return sections
+}
+
+// trimTrailingBlank drops blank lines at the end of the file and makes sure
+// it ends with a newline
+func (f *sshConfigFile) trimTrailingBlank() {
+	for len(f.items) > 0 {
+		last := f.items[len(f.items)-1].block
+		if last == nil {
+			return
+		}
+		for len(last.lines) > 0 && last.lines[len(last.lines)-1].isBlank() {
+			last.lines = last.lines[:len(last.lines)-1]
+		}
+		if last.header != nil || len(last.lines) > 0 {
+			break
+		}
+		f.items = f.items[:len(f.items)-1]
+	}
+	if len(f.items) > 0 && f.items[len(f.items)-1].block != nil {
+		last := f.items[len(f.items)-1].block
+		lines := last.lines
+		if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1].raw, "\n") {
+			lines[len(lines)-1].raw += "\n"
+		} else if len(lines) == 0 && !strings.HasSuffix(last.header.raw, "\n") {
+			last.header.raw += "\n"
+		}
+	}
+}
+
+// lines returns the lines outside managed sections, in file order
+func (f *sshConfigFile) lines() []sshLine {
+	var lines []sshLine
+	for _, item := range f.items {
+		if item.block == nil {
+			continue
+		}
+		if item.block.header != nil {
+			lines = append(lines, *item.block.header)
+		}
+		lines = append(lines, item.block.lines...)
+	}
+	return lines
+}
+
+// removeLineRange drops the lines numbered from to through to outside
+// managed sections. The body of a Host block whose header is removed stays
+// in place as lines without a header.
+func (f *sshConfigFile) removeLineRange(from, to int) {
+	inRange := func(line sshLine) bool {
+		return line.num >= from && line.num <= to
+	}
+
+	var kept []sshItem
+	for _, item := range f.items {
+		block := item.block
+		if block == nil {
+			kept = append(kept, item)
+			continue
+		}
+		if block.header != nil && inRange(*block.header) {
+			block.header = nil
+		}
+		var lines []sshLine
+		for _, line := range block.lines {
+			if !inRange(line) {
+				lines = append(lines, line)
+			}
+		}
+		block.lines = lines
+		if block.header != nil || len(block.lines) > 0 {
+			kept = append(kept, item)
+		}
+	}
+	f.items = kept
+}
+
+// removeLines drops every line outside managed sections for which match returns true
+func (f *sshConfigFile) removeLines(match func(sshLine) bool) {
+	var kept []sshItem
+	for _, item := range f.items {
+		block := item.block
+		if block == nil {
+			kept = append(kept, item)
+			continue
+		}
+		var lines []sshLine
+		for _, line := range block.lines {
+			if !match(line) {
+				lines = append(lines, line)
+			}
+		}
+		block.lines = lines
+		if block.header != nil || len(block.lines) > 0 {
+			kept = append(kept, item)
+		}
+	}
+	f.items = kept
+}
+
+// commentOut turns the directive on line num into a comment with a note.
+// It reports whether the line was found.
+func (f *sshConfigFile) commentOut(num int, note string) bool {
+	for _, item := range f.items {
+		if item.block == nil {
+			continue
+		}
+		for i, line := range item.block.lines {
+			if line.num != num || line.keyword == "" {
+				continue
+			}
+			indent := line.raw[:len(line.raw)-len(strings.TrimLeft(line.raw, " \t"))]
+			ending := line.raw[len(strings.TrimRight(line.raw, "\r\n")):]
+			item.block.lines[i] = parseSSHLine(fmt.Sprintf("%s# %s (%s)%s", indent, line.text(), note, ending), num)
+			return true
+		}
+	}
+	return false
+}
+
+// replaceHeader replaces the Host or Match line num with header, keeping the
+// old line as a comment with a note. It reports whether the line was found.
+func (f *sshConfigFile) replaceHeader(num int, header, note string) bool {
+	for _, item := range f.items {
+		block := item.block
+		if block == nil || block.header == nil || block.header.num != num {
+			continue
+		}
+		old := *block.header
+		indent := old.raw[:len(old.raw)-len(strings.TrimLeft(old.raw, " \t"))]
+		ending := old.raw[len(strings.TrimRight(old.raw, "\r\n")):]
+		if ending == "" {
+			ending = "\n"
+		}
+		replaced := parseSSHLine(indent+header+ending, num)
+		block.header = &replaced
+		comment := parseSSHLine(fmt.Sprintf("%s    # was: %s (%s)%s", indent, old.text(), note, ending), 0)
+		block.lines = append([]sshLine{comment}, block.lines...)
+		return true
+	}
+	return false
+}
+
+// prepend inserts lines of generated text at the top of the file
+func (f *sshConfigFile) prepend(text string) {
+	block := &sshBlock{}
+	for _, raw := range splitLines(text) {
+		block.lines = append(block.lines, parseSSHLine(raw, 0))
+	}
+	f.items = append([]sshItem{{block: block}}, f.items...)
+}
+
+// trimLeadingBlank drops blank lines at the top of the file
+func (f *sshConfigFile) trimLeadingBlank() {
+	for len(f.items) > 0 {
+		block := f.items[0].block
+		if block == nil || block.header != nil {
+			return
+		}
+		for len(block.lines) > 0 && block.lines[0].isBlank() {
+			block.lines = block.lines[1:]
+		}
+		if len(block.lines) > 0 {
+			return
+		}
+		f.items = f.items[1:]
+	}
+}
+
+// newSSHSection starts a generated managed section for a cluster
+func newSSHSection(name string) *sshSection {
+	start, end := clusterMarkers(name)
+	return &sshSection{
+		name:  name,
+		start: parseSSHLine(start+"\n", 0),
+		end:   parseSSHLine(end+"\n", 0),
+	}
+}
+
+// addLines appends generated text, such as the output of tsh config
+func (s *sshSection) addLines(text string) {
+	for _, raw := range splitLines(text) {
+		if !strings.HasSuffix(raw, "\n") {
+			raw += "\n"
+		}
+		s.blocks = appendSSHLine(s.blocks, parseSSHLine(raw, 0))
+	}
+}
+
+// addComment appends a comment line
+func (s *sshSection) addComment(text string) {
+	s.addLines("# " + text + "\n")
+}
+
+// addBlank appends an empty line
+func (s *sshSection) addBlank() {
+	s.addLines("\n")
+}
+
+// addHost starts a Host block for patterns
+func (s *sshSection) addHost(patterns ...string) {
+	s.addLines("Host " + strings.Join(patterns, " ") + "\n")
+}
+
+// addMatch starts a Match block with the given criteria
+func (s *sshSection) addMatch(criteria string) {
+	s.addLines("Match " + criteria + "\n")
+}
+
+// addDirective appends an indented directive to the current block.
+// value is written as is, so callers quote paths themselves.
+func (s *sshSection) addDirective(keyword, value string) {
+	s.addLines("    " + keyword + " " + value + "\n")
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readSSHFixtures returns the configs in testdata/sshconfig by file name
+func readSSHFixtures(t *testing.T) map[string]string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join("testdata", "sshconfig", "*.conf"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no SSH config fixtures: %v", err)
+	}
+	fixtures := map[string]string{}
+	for _, path := range paths {
+		fixtures[filepath.Base(path)] = readTestFile(t, path)
+	}
+	return fixtures
+}
+
+func TestSSHConfigRoundTrip(t *testing.T) {
+	for name, content := range readSSHFixtures(t) {
+		t.Run(name, func(t *testing.T) {
+			file := parseSSHConfig(content)
+			if problems := file.fatalProblems(); len(problems) > 0 {
+				t.Fatalf("unexpected problems: %v", problems)
+			}
+			if got := file.String(); got != content {
+				t.Errorf("round trip changed the bytes:\ngot  %q\nwant %q", got, content)
+			}
+		})
+	}
+}
+
+func TestSSHConfigIncludeKeepsUserBytes(t *testing.T) {
+	prefix := includeComment + "\nInclude " + includeRelPath + "\n\n"
+	for name, content := range readSSHFixtures(t) {
+		if name == "managed_section.conf" {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			file := parseSSHConfig(content)
+			file.removeSections()
+			ensureInclude(file)
+			if got := file.String(); got != prefix+content {
+				t.Errorf("adding the Include changed the user's lines:\ngot  %q\nwant %q", got, prefix+content)
+			}
+		})
+	}
+}
+
+func TestSSHConfigMigrateManagedSection(t *testing.T) {
+	file := parseSSHConfig(readSSHFixtures(t)["managed_section.conf"])
+	sections := file.removeSections()
+	if _, ok := sections["default"]; !ok || len(sections) != 1 {
+		t.Fatalf("removeSections() = %v, want the default section", sections)
+	}
+	ensureInclude(file)
+
+	// Only the section and the old Include go; the final line gains a newline
+	want := includeComment + "\nInclude " + includeRelPath + "\n\n" +
+		"Host github.com\n    User git\n\n\nHost *\r\n    IdentitiesOnly yes\n"
+	if got := file.String(); got != want {
+		t.Errorf("migrated config:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestParseSSHLine(t *testing.T) {
+	tests := []struct {
+		raw     string
+		keyword string
+		args    []string
+	}{
+		{"\tHostName\tbuild.example.com\n", "hostname", []string{"build.example.com"}},
+		{"    HostName=bastion.example.com\n", "hostname", []string{"bastion.example.com"}},
+		{"    Port = 2222\n", "port", []string{"2222"}},
+		{"    User deploy\r\n", "user", []string{"deploy"}},
+		{"Match host *.internal exec \"test -f ~/.vpn\"\n", "match", []string{"host", "*.internal", "exec", "test -f ~/.vpn"}},
+		{"   # indented comment\n", "", nil},
+		{"\r\n", "", nil},
+	}
+	for _, tt := range tests {
+		line := parseSSHLine(tt.raw, 1)
+		if line.keyword != tt.keyword || strings.Join(line.args, "|") != strings.Join(tt.args, "|") {
+			t.Errorf("parseSSHLine(%q) = %q %q, want %q %q", tt.raw, line.keyword, line.args, tt.keyword, tt.args)
+		}
+	}
+}
+
+func TestCommentOutKeepsLineEnding(t *testing.T) {
+	file := parseSSHConfig(readSSHFixtures(t)["crlf.conf"])
+	if !file.commentOut(3, "disabled") {
+		t.Fatal("line 3 not found")
+	}
+	want := "Host web\r\n    HostName web.example.com\r\n    # User deploy (disabled)\r\n\r\n# comment\r\nHost *\r\n    Compression yes\r\n"
+	if got := file.String(); got != want {
+		t.Errorf("commentOut() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestSSHFixturesHaveTheirQuirks(t *testing.T) {
+	// Guard against an editor normalising the fixtures
+	checks := map[string]func(string) bool{
+		"crlf.conf":             func(s string) bool { return strings.Contains(s, "\r\n") },
+		"tabs.conf":             func(s string) bool { return strings.Contains(s, "\t") },
+		"no_final_newline.conf": func(s string) bool { return !strings.HasSuffix(s, "\n") },
+	}
+	fixtures := readSSHFixtures(t)
+	for name, check := range checks {
+		if !check(fixtures[name]) {
+			t.Errorf("fixture %s lost what it tests", name)
+		}
+	}
+}
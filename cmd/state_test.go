@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONState(t *testing.T) {
+	useTestEnv(t, &fakeTsh{})
+	name := filepath.Join("sub", "state.json")
+
+	got := map[string]int{"kept": 1}
+	if err := loadJSONState(name, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got["kept"] != 1 {
+		t.Errorf("missing file changed the value: %v", got)
+	}
+
+	if err := saveJSONState(name, map[string]int{"a": 2}); err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	if err := loadJSONState(name, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got["a"] != 2 {
+		t.Errorf("loadJSONState() = %v, want map[a:2]", got)
+	}
+
+	dir, err := stateDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadJSONState(name, &got); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("loadJSONState() of a corrupt file = %v, want an error naming %s", err, path)
+	}
+}
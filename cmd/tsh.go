@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// tshStatus is the subset of `tsh status` that scicom-helper uses.
+// Traits are not available when parsed from text output.
+type tshStatus struct {
+	ProfileURL string
+	Username   string
+	Cluster    string
+	Roles      []string
+	Logins     []string
+	Traits     map[string][]string
+	ValidUntil time.Time // certificate expiry
+}
+
+// tshNode is a single node from `tsh ls`.
+// Only Hostname is available when parsed from text output.
+type tshNode struct {
+	ID       string
+	Hostname string
+	Addr     string
+	Labels   map[string]string
+}
+
+// sshSession describes a single `tsh ssh` invocation
+type sshSession struct {
+	Proxy   string   // proxy of the cluster, empty for the active profile
+	Target  string   // login@node
+	Command []string // remote command, empty for an interactive shell
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+// Tsh is the set of Teleport CLI operations scicom-helper relies on.
+// execTsh shells out to the real binary; the tests use an in-memory fakeTsh.
+type Tsh interface {
+	// Installed reports whether the tsh binary is available
+	Installed() bool
+	// Status returns the profile for proxy (the active profile if proxy is
+	// empty), or an error if not logged in or the session has expired
+	Status(proxy string) (*tshStatus, error)
+	// Ls returns all nodes the user can access through proxy
+	Ls(proxy string) ([]tshNode, error)
+	// Config returns the OpenSSH configuration generated by `tsh config`
+	Config(proxy string) (string, error)
+	// Login logs in to proxy with the given auth connector, interactively.
+	// If a valid profile for proxy exists, tsh switches to it without prompting.
+	Login(proxy, auth string) error
+	// Logout removes the local Teleport profile for proxy
+	Logout(proxy string) error
+	// SSH runs `tsh ssh`; the context bounds the lifetime of the process
+	SSH(ctx context.Context, session sshSession) error
+	// Proxy returns the ProxyCommand line that tunnels OpenSSH through `tsh proxy ssh`
+	Proxy(cluster, proxy string) string
+}
+
+// tshClient is the Tsh implementation used by all commands
+var tshClient Tsh = execTsh{}
+
+// execTsh implements Tsh by running the tsh binary
+type execTsh struct{}
+
+func (execTsh) Installed() bool {
+	_, err := exec.LookPath("tsh")
+	return err == nil
+}
+
+// proxyArgs returns the global tsh flag selecting the profile for proxy
+func proxyArgs(proxy string) []string {
+	if proxy == "" {
+		return nil
+	}
+	return []string{fmt.Sprintf("--proxy=%s", proxy)}
+}
+
+func (execTsh) Status(proxy string) (*tshStatus, error) {
+	var status *tshStatus
+	out, err := runCommand("tsh", "status", "--format=json")
+	if err == nil && json.Valid([]byte(out)) {
+		status, err = decodeTshStatus([]byte(out), proxy)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Older tsh versions don't support --format=json for status
+		out, err := runCommand("tsh", "status")
+		if err != nil {
+			return nil, err
+		}
+		status, err = parseTshStatusText(out, proxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !status.ValidUntil.IsZero() && time.Now().After(status.ValidUntil) {
+		return nil, fmt.Errorf("Teleport session for %s expired at %s", status.Cluster, status.ValidUntil.Format("2006-01-02 15:04:05"))
+	}
+	return status, nil
+}
+
+func (execTsh) Ls(proxy string) ([]tshNode, error) {
+	args := append(proxyArgs(proxy), "ls")
+	if out, err := runCommand("tsh", append(args, "--format=json")...); err == nil {
+		if nodes, err := decodeTshNodes([]byte(out)); err == nil {
+			return nodes, nil
+		}
+	}
+
+	// Older tsh versions only support the names format
+	out, err := runCommand("tsh", append(args, "--format=names")...)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []tshNode{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			nodes = append(nodes, tshNode{Hostname: line})
+		}
+	}
+	return nodes, nil
+}
+
+func (execTsh) Config(proxy string) (string, error) {
+	return runCommand("tsh", append(proxyArgs(proxy), "config")...)
+}
+
+func (execTsh) Login(proxy, auth string) error {
+	cmd := exec.Command("tsh", "login",
+		fmt.Sprintf("--proxy=%s", proxy),
+		fmt.Sprintf("--auth=%s", auth))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func (execTsh) Logout(proxy string) error {
+	_, err := runCommand("tsh", append(proxyArgs(proxy), "logout")...)
+	return err
+}
+
+func (execTsh) SSH(ctx context.Context, session sshSession) error {
+	args := append(proxyArgs(session.Proxy), "ssh", session.Target)
+	args = append(args, session.Command...)
+	cmd := exec.CommandContext(ctx, "tsh", args...)
+	cmd.Stdin = session.Stdin
+	cmd.Stdout = session.Stdout
+	cmd.Stderr = session.Stderr
+	if session.Stdin == nil {
+		killProcessGroupOnCancel(cmd)
+	}
+	// Don't wait for output from processes that outlive a cancelled tsh
+	cmd.WaitDelay = 2 * time.Second
+	return cmd.Run()
+}
+
+func (execTsh) Proxy(cluster, proxy string) string {
+	return fmt.Sprintf("\"tsh\" proxy ssh --cluster=%s --proxy=%s:443 %%r@%%h:%%p", cluster, proxy)
+}
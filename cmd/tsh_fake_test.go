@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTsh is an in-memory Tsh for exercising the SSH config generation and
+// login selection logic without a real Teleport cluster
+type fakeTsh struct {
+	// profiles maps proxy to the logged-in profile; a missing proxy means not logged in
+	profiles map[string]*tshStatus
+	// active is the proxy of the active profile
+	active string
+	// nodes and configs are keyed by proxy
+	nodes   map[string][]tshNode
+	configs map[string]string
+	// allowed maps node name to the logins that may connect to it;
+	// any other login is rejected with an "access denied" error
+	allowed map[string][]string
+	// calls records every invocation as "method args..."
+	calls []string
+	// sshErr, if set, is returned by SSH for allowed logins, e.g. the
+	// exitStatus of a failing remote command
+	sshErr error
+	// delays makes SSH as a login take this long, honouring its context
+	delays map[string]time.Duration
+	// running and maxRunning count the SSH calls in progress
+	running, maxRunning int
+	mu                  sync.Mutex
+}
+
+func (f *fakeTsh) record(format string, args ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, fmt.Sprintf(format, args...))
+}
+
+// resolve maps an empty proxy to the active profile
+func (f *fakeTsh) resolve(proxy string) string {
+	if proxy == "" {
+		return f.active
+	}
+	return proxy
+}
+
+func (f *fakeTsh) Installed() bool {
+	return true
+}
+
+func (f *fakeTsh) Status(proxy string) (*tshStatus, error) {
+	f.record("status %s", proxy)
+	status, ok := f.profiles[f.resolve(proxy)]
+	if !ok {
+		return nil, fmt.Errorf("not logged in")
+	}
+	return status, nil
+}
+
+func (f *fakeTsh) Ls(proxy string) ([]tshNode, error) {
+	f.record("ls %s", proxy)
+	if _, ok := f.profiles[f.resolve(proxy)]; !ok {
+		return nil, fmt.Errorf("not logged in")
+	}
+	return f.nodes[f.resolve(proxy)], nil
+}
+
+func (f *fakeTsh) Config(proxy string) (string, error) {
+	f.record("config %s", proxy)
+	return f.configs[f.resolve(proxy)], nil
+}
+
+func (f *fakeTsh) Login(proxy, auth string) error {
+	f.record("login %s %s", proxy, auth)
+	if f.profiles == nil {
+		f.profiles = map[string]*tshStatus{}
+	}
+	if _, ok := f.profiles[proxy]; !ok {
+		f.profiles[proxy] = &tshStatus{Username: "fake-user", Cluster: proxy, Logins: []string{"ubuntu"}}
+	}
+	f.active = proxy
+	return nil
+}
+
+func (f *fakeTsh) Logout(proxy string) error {
+	f.record("logout %s", proxy)
+	delete(f.profiles, f.resolve(proxy))
+	return nil
+}
+
+func (f *fakeTsh) SSH(ctx context.Context, session sshSession) error {
+	f.record("ssh %s %s %s", session.Proxy, session.Target, strings.Join(session.Command, " "))
+	f.mu.Lock()
+	f.running++
+	if f.running > f.maxRunning {
+		f.maxRunning = f.running
+	}
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.running--
+		f.mu.Unlock()
+	}()
+
+	login, node, _ := strings.Cut(session.Target, "@")
+	if delay := f.delays[login]; delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, allowed := range f.allowed[node] {
+		if allowed == login {
+			return f.sshErr
+		}
+	}
+
+	// Like tsh, report the denial on stderr and exit with status 1
+	if session.Stderr != nil {
+		fmt.Fprintf(session.Stderr, "ERROR: access denied to %s connecting to %s\n", login, node)
+	}
+	return exitStatus(1)
+}
+
+// exitStatus returns the *exec.ExitError of a process exiting with code
+func exitStatus(code int) error {
+	return exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+}
+
+func (f *fakeTsh) Proxy(cluster, proxy string) string {
+	return fmt.Sprintf("tsh proxy ssh --cluster=%s --proxy=%s:443 %%r@%%h:%%p", cluster, proxy)
+}
+
+// useTestEnv points HOME and the XDG directories at a temporary directory,
+// resets the configuration and installs fake as the Tsh client. It returns
+// the temporary home directory.
+func useTestEnv(t *testing.T, fake *fakeTsh) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("XDG_STATE_HOME", filepath.Join(home, ".local", "state"))
+
+	savedCfg, savedClient := cfg, tshClient
+	cfg, tshClient = defaultConfig(), fake
+	t.Cleanup(func() { cfg, tshClient = savedCfg, savedClient })
+	return home
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tshStatusJSON mirrors the output of `tsh status --format=json`
+type tshStatusJSON struct {
+	Active   *tshProfileJSON  `json:"active"`
+	Profiles []tshProfileJSON `json:"profiles"`
+}
+
+// tshProfileJSON is a single profile in `tsh status --format=json`
+type tshProfileJSON struct {
+	ProfileURL string              `json:"profile_url"`
+	Username   string              `json:"username"`
+	Cluster    string              `json:"cluster"`
+	Roles      []string            `json:"roles"`
+	Logins     []string            `json:"logins"`
+	Traits     map[string][]string `json:"traits"`
+	ValidUntil time.Time           `json:"valid_until"`
+}
+
+// tshNodeJSON mirrors a single entry of `tsh ls --format=json`
+type tshNodeJSON struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		Addr      string `json:"addr"`
+		Hostname  string `json:"hostname"`
+		CmdLabels map[string]struct {
+			Result string `json:"result"`
+		} `json:"cmd_labels"`
+	} `json:"spec"`
+}
+
+// decodeTshStatus parses `tsh status --format=json` output and returns the
+// profile for proxy, or the active profile if proxy is empty
+func decodeTshStatus(data []byte, proxy string) (*tshStatus, error) {
+	var raw tshStatusJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse tsh status: %v", err)
+	}
+
+	var profiles []tshProfileJSON
+	if raw.Active != nil {
+		profiles = append(profiles, *raw.Active)
+	}
+	profiles = append(profiles, raw.Profiles...)
+
+	if proxy == "" {
+		if raw.Active == nil {
+			return nil, fmt.Errorf("no active Teleport profile")
+		}
+		return raw.Active.status(), nil
+	}
+
+	for _, p := range profiles {
+		if proxyHost(p.ProfileURL) == proxyHost(proxy) {
+			return p.status(), nil
+		}
+	}
+	return nil, fmt.Errorf("not logged in to %s", proxy)
+}
+
+// proxyHost strips the scheme and port from a proxy address or profile URL
+func proxyHost(addr string) string {
+	if i := strings.Index(addr, "://"); i >= 0 {
+		addr = addr[i+3:]
+	}
+	if i := strings.IndexAny(addr, ":/"); i >= 0 {
+		addr = addr[:i]
+	}
+	return strings.ToLower(addr)
+}
+
+// status converts a JSON profile into a tshStatus
+func (p tshProfileJSON) status() *tshStatus {
+	return &tshStatus{
+		ProfileURL: p.ProfileURL,
+		Username:   p.Username,
+		Cluster:    p.Cluster,
+		Roles:      p.Roles,
+		Logins:     filterLogins(p.Logins),
+		Traits:     p.Traits,
+		ValidUntil: p.ValidUntil,
+	}
+}
+
+// decodeTshNodes parses `tsh ls --format=json` output into nodes sorted by hostname
+func decodeTshNodes(data []byte) ([]tshNode, error) {
+	var raw []tshNodeJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse tsh ls: %v", err)
+	}
+
+	nodes := []tshNode{}
+	for _, n := range raw {
+		if n.Kind != "" && n.Kind != "node" {
+			continue
+		}
+
+		// Static and command labels are shown together, as in `tsh ls`
+		labels := map[string]string{}
+		for k, v := range n.Metadata.Labels {
+			labels[k] = v
+		}
+		for k, v := range n.Spec.CmdLabels {
+			labels[k] = v.Result
+		}
+
+		hostname := n.Spec.Hostname
+		if hostname == "" {
+			hostname = n.Metadata.Name
+		}
+
+		nodes = append(nodes, tshNode{
+			ID:       n.Metadata.Name,
+			Hostname: hostname,
+			Addr:     n.Spec.Addr,
+			Labels:   labels,
+		})
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Hostname < nodes[j].Hostname })
+	return nodes, nil
+}
+
+// filterLogins drops Teleport's internal placeholder logins
+// (for example -teleport-nologin-<uuid> and -teleport-internal-join)
+func filterLogins(logins []string) []string {
+	filtered := []string{}
+	for _, login := range logins {
+		if login != "" && !strings.HasPrefix(login, "-teleport-") {
+			filtered = append(filtered, login)
+		}
+	}
+	return filtered
+}
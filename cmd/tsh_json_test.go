@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testStatusJSON = `{
+  "active": {
+    "profile_url": "https://teleport.example.com:443",
+    "username": "alice",
+    "cluster": "example",
+    "roles": ["access"],
+    "logins": ["ubuntu", "root", "-teleport-internal-join"],
+    "valid_until": "2030-01-01T20:00:00Z"
+  },
+  "profiles": [
+    {
+      "profile_url": "https://other.example.com",
+      "username": "alice@example.com",
+      "cluster": "other",
+      "logins": ["admin"],
+      "valid_until": "2030-01-02T08:00:00Z"
+    }
+  ]
+}`
+
+func TestDecodeTshStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		proxy       string
+		wantCluster string
+		wantUser    string
+		wantLogins  []string
+		wantErr     bool
+	}{
+		{
+			name:        "active profile",
+			data:        testStatusJSON,
+			proxy:       "",
+			wantCluster: "example",
+			wantUser:    "alice",
+			wantLogins:  []string{"ubuntu", "root"},
+		},
+		{
+			name:        "active profile by proxy with port",
+			data:        testStatusJSON,
+			proxy:       "teleport.example.com:443",
+			wantCluster: "example",
+			wantUser:    "alice",
+			wantLogins:  []string{"ubuntu", "root"},
+		},
+		{
+			name:        "other profile, case-insensitive",
+			data:        testStatusJSON,
+			proxy:       "Other.Example.com",
+			wantCluster: "other",
+			wantUser:    "alice@example.com",
+			wantLogins:  []string{"admin"},
+		},
+		{
+			name:    "unknown proxy",
+			data:    testStatusJSON,
+			proxy:   "missing.example.com",
+			wantErr: true,
+		},
+		{
+			name:    "no active profile",
+			data:    `{"active": null, "profiles": []}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid JSON",
+			data:    `{"active":`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := decodeTshStatus([]byte(tt.data), tt.proxy)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.Cluster != tt.wantCluster || status.Username != tt.wantUser {
+				t.Errorf("got cluster %q user %q, want %q %q", status.Cluster, status.Username, tt.wantCluster, tt.wantUser)
+			}
+			if !reflect.DeepEqual(status.Logins, tt.wantLogins) {
+				t.Errorf("Logins = %q, want %q", status.Logins, tt.wantLogins)
+			}
+			if status.ValidUntil.IsZero() {
+				t.Errorf("ValidUntil not set")
+			}
+		})
+	}
+}
+
+func TestDecodeTshStatusValidUntil(t *testing.T) {
+	status, err := decodeTshStatus([]byte(testStatusJSON), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2030, 1, 1, 20, 0, 0, 0, time.UTC)
+	if !status.ValidUntil.Equal(want) {
+		t.Errorf("ValidUntil = %v, want %v", status.ValidUntil, want)
+	}
+}
+
+func TestDecodeTshNodes(t *testing.T) {
+	data := `[
+  {
+    "kind": "node",
+    "metadata": {"name": "uuid-2", "labels": {"env": "prod"}},
+    "spec": {"addr": "10.0.0.2:3022", "hostname": "web-2",
+             "cmd_labels": {"arch": {"result": "x86_64"}}}
+  },
+  {
+    "kind": "node",
+    "metadata": {"name": "uuid-1"},
+    "spec": {"addr": "10.0.0.1:3022", "hostname": "web-1"}
+  },
+  {
+    "metadata": {"name": "legacy-node"},
+    "spec": {}
+  },
+  {
+    "kind": "app",
+    "metadata": {"name": "grafana"},
+    "spec": {}
+  }
+]`
+
+	nodes, err := decodeTshNodes([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []tshNode{
+		{ID: "legacy-node", Hostname: "legacy-node", Labels: map[string]string{}},
+		{ID: "uuid-1", Hostname: "web-1", Addr: "10.0.0.1:3022", Labels: map[string]string{}},
+		{ID: "uuid-2", Hostname: "web-2", Addr: "10.0.0.2:3022", Labels: map[string]string{"env": "prod", "arch": "x86_64"}},
+	}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Errorf("decodeTshNodes() =\n%+v\nwant\n%+v", nodes, want)
+	}
+}
+
+func TestDecodeTshNodesInvalid(t *testing.T) {
+	if _, err := decodeTshNodes([]byte(`{"not": "a list"}`)); err == nil {
+		t.Error("expected an error for a non-list document")
+	}
+}
+
+func TestProxyHost(t *testing.T) {
+	tests := map[string]string{
+		"teleport.example.com":             "teleport.example.com",
+		"teleport.example.com:443":         "teleport.example.com",
+		"https://Teleport.Example.com:443": "teleport.example.com",
+		"https://teleport.example.com/web": "teleport.example.com",
+	}
+	for addr, want := range tests {
+		if got := proxyHost(addr); got != want {
+			t.Errorf("proxyHost(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
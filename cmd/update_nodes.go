@@ -7,6 +7,8 @@ import (
 	"runtime"
 	"strings"
 	"time"
+
+	"github.com/spf13/cobra"
 )
 
 const (
@@ -14,6 +16,95 @@ const (
 	markerEnd   = "# END SCICOM-HELPER TELEPORT CONFIG"
 )
 
+var updateNodesCmd = &cobra.Command{
+	Use:   "update-nodes",
+	Short: "Update SSH config with all accessible Teleport nodes",
+	Long: `Update SSH config with all accessible Teleport nodes.
+
+The hosts are written to ~/.ssh/config.d/scicom-helper.conf, which is pulled
+in by a managed Include line at the top of ~/.ssh/config. Blocks written into
+~/.ssh/config by older versions are moved there.
+
+Every configured cluster you are logged in to gets its own block in the
+generated file. Clusters you are not logged in to keep their previous block.
+
+Settings in ~/.ssh/config that change the HostName, User, Port, ProxyCommand
+or HostKeyAlias of the generated hosts are reported with their line number;
+--fix-conflicts comments them out. Lines in blocks that also apply to other
+hosts, such as Host *, are only reported, with a Match header that keeps them
+away from the Teleport hosts.
+
+Only nodes passing include_labels, exclude_labels, include_nodes and
+exclude_nodes (see 'scicom-helper config') get a host entry. The flags below
+replace the configured values for one run, e.g.
+
+  scicom-helper update-nodes --include-label env=prod --exclude-node 'gpu-*'`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireTsh(); err != nil {
+			return err
+		}
+		if err := applyNodeFilterFlags(cmd); err != nil {
+			return err
+		}
+		return updateNodes(writeOptions{dryRun: updateNodesDryRun, fixConflicts: updateNodesFixConflicts})
+	},
+}
+
+var (
+	updateNodesDryRun       bool
+	updateNodesFixConflicts bool
+	updateNodesIncludeLabel []string
+	updateNodesExcludeLabel []string
+	updateNodesIncludeNode  []string
+	updateNodesExcludeNode  []string
+)
+
+func init() {
+	updateNodesCmd.Flags().BoolVar(&updateNodesDryRun, "dry-run", false, "print a diff of the SSH config and editor settings changes without writing them")
+	updateNodesCmd.Flags().BoolVar(&updateNodesFixConflicts, "fix-conflicts", false, "comment out settings in ~/.ssh/config that change the generated Teleport hosts (never in blocks such as Host *)")
+	updateNodesCmd.Flags().StringSliceVar(&updateNodesIncludeLabel, "include-label", nil, "only add nodes matching these label selectors (overrides include_labels)")
+	updateNodesCmd.Flags().StringSliceVar(&updateNodesExcludeLabel, "exclude-label", nil, "leave out nodes matching any of these label selectors (overrides exclude_labels)")
+	updateNodesCmd.Flags().StringSliceVar(&updateNodesIncludeNode, "include-node", nil, "only add nodes whose name matches one of these globs (overrides include_nodes)")
+	updateNodesCmd.Flags().StringSliceVar(&updateNodesExcludeNode, "exclude-node", nil, "leave out nodes whose name matches any of these globs (overrides exclude_nodes)")
+	rootCmd.AddCommand(updateNodesCmd)
+}
+
+// applyNodeFilterFlags replaces the configured node filters with the ones given on the command line
+func applyNodeFilterFlags(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+	if flags.Changed("include-label") {
+		cfg.IncludeLabels = updateNodesIncludeLabel
+	}
+	if flags.Changed("exclude-label") {
+		cfg.ExcludeLabels = updateNodesExcludeLabel
+	}
+	if flags.Changed("include-node") {
+		cfg.IncludeNodes = updateNodesIncludeNode
+	}
+	if flags.Changed("exclude-node") {
+		cfg.ExcludeNodes = updateNodesExcludeNode
+	}
+	_, err := newNodeFilter(cfg)
+	return err
+}
+
+// clusterMarkers returns the markers delimiting the SSH config block of a cluster
+func clusterMarkers(name string) (string, string) {
+	return fmt.Sprintf("%s (%s)", markerStart, name), fmt.Sprintf("%s (%s)", markerEnd, name)
+}
+
+// clusterState is everything fetched from Teleport for one cluster
+type clusterState struct {
+	cluster     ClusterConfig
+	teleport    string // Teleport cluster name, used in certificate paths
+	user        string
+	tshConfig   string
+	nodes       []tshNode // nodes that get an SSH config entry
+	accessible  []tshNode // every node the user can access, for the inventory
+	defaultUser string
+}
+
 // toSSHPath converts a Windows path to SSH config format (forward slashes)
 // SSH config files expect forward slashes even on Windows
 func toSSHPath(path string) string {
@@ -24,20 +115,42 @@ func toSSHPath(path string) string {
 }
 
 // updateNodes fetches Teleport nodes and updates SSH config
-func updateNodes() error {
+func updateNodes(opts writeOptions) error {
 	fmt.Println("\n=== Update Teleport Nodes ===")
 	fmt.Println()
 
-	// Check if logged in
-	if !isTeleportLoggedIn() {
+	// Find the clusters we are logged in to
+	clusters := cfg.clusterProfiles()
+	var loggedIn []ClusterConfig
+	statuses := map[string]*tshStatus{}
+	for _, cluster := range clusters {
+		status, err := tshClient.Status(cluster.Proxy)
+		if err != nil {
+			if len(clusters) > 1 {
+				fmt.Printf("Skipping cluster %s: not logged in (run 'scicom-helper clusters login %s')\n", cluster.Name, cluster.Name)
+			}
+			continue
+		}
+		loggedIn = append(loggedIn, cluster)
+		statuses[cluster.Name] = status
+	}
+
+	if len(loggedIn) == 0 {
 		fmt.Println("You are not logged in to Teleport")
 		fmt.Println("Please run 'Teleport Setup' first")
 		return fmt.Errorf("not logged in to Teleport")
 	}
 
+	// Serialise with other scicom-helper runs until every file is written
+	unlock, err := acquireLock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// Automatically configure VS Code for Teleport
 	fmt.Println("Step 1/2: Configuring VS Code for Teleport...")
-	if err := configureVSCode(); err != nil {
+	if err := configureVSCode(opts); err != nil {
 		fmt.Printf("Warning: VS Code configuration failed: %v\n", err)
 		fmt.Println("Continuing with SSH config update...")
 	}
@@ -50,41 +163,295 @@ func updateNodes() error {
 		return fmt.Errorf("failed to get home directory: %v", err)
 	}
 
+	// ~/.ssh and ~/.ssh/config.d are created when the files are written
 	sshDir := filepath.Join(home, ".ssh")
 	sshConfig := filepath.Join(sshDir, "config")
 
-	// Ensure .ssh directory exists
-	if err := os.MkdirAll(sshDir, 0700); err != nil {
-		return fmt.Errorf("failed to create .ssh directory: %v", err)
+	// Fetch configuration and nodes for each cluster
+	var states []clusterState
+	for _, cluster := range loggedIn {
+		state, err := fetchClusterState(home, cluster, statuses[cluster.Name])
+		if err != nil {
+			return fmt.Errorf("cluster %s: %v", cluster.Name, err)
+		}
+		if len(state.accessible) == 0 {
+			fmt.Printf("Warning: No nodes found in cluster %s\n", cluster.Name)
+		} else if len(state.nodes) == 0 {
+			fmt.Printf("Warning: No nodes in cluster %s match the include/exclude settings\n", cluster.Name)
+		}
+		states = append(states, state)
 	}
 
-	// Backup existing config
-	if _, err := os.Stat(sshConfig); err == nil {
-		backupPath := fmt.Sprintf("%s.backup.%s", sshConfig, time.Now().Format("20060102_150405"))
-		fmt.Printf("Backing up existing SSH config to: %s\n", backupPath)
+	includePath := sshIncludePath(sshDir)
 
-		data, err := os.ReadFile(sshConfig)
-		if err != nil {
-			return fmt.Errorf("failed to read SSH config: %v", err)
+	// Read existing config and generated file
+	data, err := os.ReadFile(sshConfig)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read SSH config: %v", err)
+	}
+	exists := err == nil
+
+	includeData, err := os.ReadFile(includePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %v", includePath, err)
+	}
+	includeExists := err == nil
+
+	mainFile := parseSSHConfig(string(data))
+	includeFile := parseSSHConfig(string(includeData))
+	if err := checkSSHConfig(sshConfig, mainFile); err != nil {
+		return err
+	}
+	if err := checkSSHConfig(includePath, includeFile); err != nil {
+		return err
+	}
+
+	// Blocks written into ~/.ssh/config by older versions are migrated
+	// into the generated file, which takes precedence if both have one
+	legacySections := mainFile.removeSections()
+	if len(legacySections) > 0 {
+		fmt.Println("Migrating scicom-helper configuration out of ~/.ssh/config...")
+	}
+	previousSections := includeFile.removeSections()
+
+	// Sections written by the old bash scripts shadow ours
+	legacy, problems := findLegacySections(mainFile)
+	for _, problem := range problems {
+		fmt.Printf("Warning: %s: %s\n", sshConfig, problem)
+	}
+	if len(legacy) > 0 {
+		fmt.Println("Removing configuration left by the old setup scripts...")
+		removeLegacySections(mainFile, legacy)
+	}
+	for name, section := range legacySections {
+		if _, ok := previousSections[name]; !ok {
+			previousSections[name] = section
+		}
+	}
+
+	// Aliases must not collide with those of the sections we keep for
+	// configured clusters we are not logged in to
+	kept := map[string][]nodeAlias{}
+	for _, cluster := range clusters {
+		if _, ok := statuses[cluster.Name]; ok {
+			continue
+		}
+		if previous, ok := previousSections[cluster.Name]; ok {
+			kept[cluster.Name] = previous.nodeHosts(cluster.Proxy)
+		}
+	}
+	aliases, err := assignNodeAliases(states, kept)
+	if err != nil {
+		return err
+	}
+	assignNodeLogins(states, aliases)
+
+	// Build new configuration sections, keeping the previous block of
+	// configured clusters we are not logged in to
+	refreshed := map[string]*sshSection{}
+	for _, state := range states {
+		section := buildTeleportSection(home, state, aliases[state.cluster.Name])
+
+		// Keep the previous block, and its timestamp, if nothing else changed
+		if previous, ok := previousSections[state.cluster.Name]; ok && previous.stableContent() == section.stableContent() {
+			section = previous
+		}
+		refreshed[state.cluster.Name] = section
+	}
+
+	var sections []string
+	for _, cluster := range clusters {
+		if section, ok := refreshed[cluster.Name]; ok {
+			sections = append(sections, section.String())
+		} else if section, ok := previousSections[cluster.Name]; ok {
+			fmt.Printf("Keeping previous configuration for %s\n", cluster.Name)
+			sections = append(sections, section.String())
+		}
+	}
+
+	// Write the generated file first, so the Include never points at nothing
+	includeChange := fileChange{
+		path:    includePath,
+		newData: []byte(includeHeader + "\n" + strings.Join(sections, "\n")),
+		perm:    0600,
+	}
+	if includeExists {
+		includeChange.oldData = includeData
+	}
+
+	includeResult, err := applyFileChange(includeChange, opts)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", includePath, err)
+	}
+
+	// Then make sure ~/.ssh/config includes it at the top
+	ensureInclude(mainFile)
+
+	// Report settings in ~/.ssh/config that change the generated hosts
+	var generated []*sshSection
+	var checkAliases, proxies []string
+	for _, state := range states {
+		generated = append(generated, refreshed[state.cluster.Name])
+		proxies = append(proxies, state.cluster.Proxy)
+		for _, alias := range aliases[state.cluster.Name] {
+			checkAliases = append(checkAliases, alias.Alias)
+		}
+	}
+	conflicts := findSSHConflicts(generated, mainFile, checkAliases)
+	conflicts = append(conflicts, checkEffectiveConfig(generated, mainFile, checkAliases, conflicts)...)
+	if err := resolveSSHConflicts(mainFile, conflicts, proxies, opts); err != nil {
+		return err
+	}
+
+	change := fileChange{
+		path:    sshConfig,
+		newData: []byte(mainFile.String()),
+		perm:    0600,
+		backup: func() error {
+			if !exists {
+				return nil
+			}
+
+			// Backup existing config
+			entry, err := saveBackup(sshConfig, data, 0600, "update-nodes")
+			if err != nil {
+				return fmt.Errorf("failed to create backup: %v", err)
+			}
+			fmt.Printf("Backed up existing SSH config (backup %s)\n", entry.ID)
+			return nil
+		},
+	}
+	if exists {
+		change.oldData = data
+	}
+
+	result, err := applyFileChange(change, opts)
+	if err != nil {
+		return fmt.Errorf("failed to write SSH config: %v", err)
+	}
+
+	// Remember this node list for the next run and 'nodes diff'
+	inventory, err := loadInventory()
+	if err != nil {
+		return err
+	}
+	previousInventory := map[string]clusterInventory{}
+	for name, clusterNodes := range inventory.Clusters {
+		previousInventory[name] = clusterNodes
+	}
+	includeCurrent := includeResult == changeWritten || includeResult == changeUnchanged
+	if !opts.dryRun && includeCurrent {
+		for _, state := range states {
+			inventory.Clusters[state.cluster.Name] = clusterInventory{
+				Updated: time.Now(),
+				Nodes:   inventoryNodes(state.accessible),
+			}
+		}
+		if err := saveInventory(inventory); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+
+	fmt.Println()
+	if result != changeWritten && includeResult != changeWritten {
+		if result == changeUnchanged && includeResult == changeUnchanged {
+			fmt.Println("✓ No changes: SSH config is up to date")
+		} else {
+			fmt.Println("SSH config was not modified")
+		}
+		fmt.Println()
+
+		// Access changes can leave the file as it is, e.g. new nodes that
+		// exclude_nodes leaves out; report them before they become the baseline
+		for _, state := range states {
+			previous, ok := previousInventory[state.cluster.Name]
+			if !ok {
+				continue
+			}
+			current := inventoryNodes(state.accessible)
+			if added, removed := diffInventory(previous.Nodes, current); len(added) > 0 || len(removed) > 0 {
+				printInventoryDiff(state.cluster.Name, previous, current)
+				fmt.Println()
+			}
+		}
+		return nil
+	}
+
+	fmt.Println("=== Update Complete! ===")
+	fmt.Println()
+	for _, state := range states {
+		clusterAliases := aliases[state.cluster.Name]
+		if previous, ok := previousInventory[state.cluster.Name]; ok {
+			printInventoryDiff(state.cluster.Name, previous, inventoryNodes(state.accessible))
+			fmt.Println()
+			continue
 		}
 
-		if err := os.WriteFile(backupPath, data, 0600); err != nil {
-			return fmt.Errorf("failed to create backup: %v", err)
+		previous, ok := previousSections[state.cluster.Name]
+		if !ok {
+			fmt.Printf("Added %d node(s) from %s to SSH config:\n", len(clusterAliases), state.cluster.Name)
+			for _, alias := range clusterAliases {
+				if alias.Alias != alias.Hostname {
+					fmt.Printf("  - %s (%s)\n", alias.Alias, alias.Hostname)
+				} else {
+					fmt.Printf("  - %s\n", alias.Alias)
+				}
+			}
+			fmt.Println()
+			continue
 		}
+
+		added, removed := diffNodeAliases(previous.nodeAliases(), clusterAliases)
+		if len(added) == 0 && len(removed) == 0 {
+			fmt.Printf("No node changes in %s (%d node(s))\n", state.cluster.Name, len(clusterAliases))
+			fmt.Println()
+			continue
+		}
+		fmt.Printf("Node changes in %s (%d node(s)):\n", state.cluster.Name, len(clusterAliases))
+		for _, alias := range added {
+			fmt.Printf("  + %s\n", alias)
+		}
+		for _, alias := range removed {
+			fmt.Printf("  - %s\n", alias)
+		}
+		fmt.Println()
+	}
+	fmt.Println("You can now:")
+	fmt.Println("  1. Connect via SSH: ssh <node-name>")
+	fmt.Println("  2. Use VS Code Remote-SSH extension to connect")
+	fmt.Println("  3. See these hosts in VS Code's Remote Explorer")
+	fmt.Println()
+
+	return nil
+}
+
+// fetchClusterState collects the tsh config, user, nodes and default login of a cluster
+func fetchClusterState(home string, cluster ClusterConfig, status *tshStatus) (clusterState, error) {
+	state := clusterState{
+		cluster:  cluster,
+		teleport: status.Cluster,
+		user:     status.Username,
+	}
+	if state.teleport == "" {
+		state.teleport = proxyHost(cluster.Proxy)
+	}
+	// The Teleport cluster name ends up in ProxyCommand and CertificateFile
+	if err := validateNodeName(state.teleport); err != nil {
+		return state, fmt.Errorf("invalid Teleport cluster name %q: %v", state.teleport, err)
 	}
 
 	// Get Teleport configuration
-	fmt.Println("Generating Teleport SSH configuration...")
-	tshConfig, err := runCommand("tsh", "config")
+	fmt.Printf("Generating Teleport SSH configuration for %s...\n", cluster.Name)
+	tshConfig, err := tshClient.Config(cluster.Proxy)
 	if err != nil {
-		return fmt.Errorf("failed to get tsh config: %v", err)
+		return state, fmt.Errorf("failed to get tsh config: %v", err)
 	}
+	state.tshConfig = tshConfig
 
 	// Get user info for certificate paths
-	user, err := getTeleportUser()
-	if err != nil {
+	if state.user == "" {
 		// If we can't get the user from tsh status, try to auto-detect from .tsh directory
-		tshKeysDir := filepath.Join(home, ".tsh", "keys", teleportProxy)
+		tshKeysDir := filepath.Join(home, ".tsh", "keys", cluster.Proxy)
 		entries, readErr := os.ReadDir(tshKeysDir)
 		if readErr == nil && len(entries) > 0 {
 			// Find first file that doesn't end with -ssh or other suffixes
@@ -92,146 +459,239 @@ func updateNodes() error {
 				name := entry.Name()
 				if !entry.IsDir() && !strings.HasSuffix(name, "-ssh") &&
 					!strings.HasSuffix(name, ".pub") && !strings.HasSuffix(name, ".cert") {
-					user = strings.TrimSuffix(name, filepath.Ext(name))
-					fmt.Printf("Auto-detected Teleport user: %s\n", user)
+					state.user = strings.TrimSuffix(name, filepath.Ext(name))
+					fmt.Printf("Auto-detected Teleport user: %s\n", state.user)
 					break
 				}
 			}
 		}
 
-		// If still no user, return the original error
-		if user == "" {
-			return fmt.Errorf("failed to get Teleport user: %v\n\nPlease run: tsh status\nAnd share the output", err)
+		// If still no user, give up on this cluster
+		if state.user == "" {
+			return state, fmt.Errorf("failed to get Teleport user\n\nPlease run: tsh status\nAnd share the output")
 		}
 	}
 
 	// Get list of nodes
-	fmt.Println("Fetching node list from Teleport...")
-	nodes, err := getTeleportNodes()
+	fmt.Printf("Fetching node list from %s...\n", cluster.Name)
+	nodes, err := tshClient.Ls(cluster.Proxy)
 	if err != nil {
-		return fmt.Errorf("failed to get nodes: %v", err)
+		return state, fmt.Errorf("failed to get nodes: %v", err)
 	}
-
-	if len(nodes) == 0 {
-		fmt.Println("Warning: No nodes found")
-		return nil
+	// Names end up in Host and HostName lines, so anything that could break
+	// the config is skipped rather than written
+	for _, node := range nodes {
+		if err := validateNodeName(node.Hostname); err != nil {
+			fmt.Printf("Warning: skipping node %q in %s: %v\n", node.Hostname, cluster.Name, err)
+			continue
+		}
+		state.accessible = append(state.accessible, node)
+	}
+	if state.nodes, err = filterNodes(cluster.Name, state.accessible); err != nil {
+		return state, err
 	}
 
-	// Get available logins and pick the best default
-	defaultUser := "ubuntu" // fallback
-	allLogins, err := getAllLogins()
-	if err == nil && len(allLogins) > 0 {
-		// Prioritize: ubuntu > root > others
-		defaultUser = pickDefaultLogin(allLogins)
-		fmt.Printf("Using default login: %s\n", defaultUser)
+	// Pick the best default from the logins granted on this cluster
+	state.defaultUser = pickDefaultLogin(status.Logins)
+	fmt.Printf("Using default login for %s: %s\n", cluster.Name, state.defaultUser)
+
+	return state, nil
+}
+
+// validateNodeName checks that a node name or alias is a plain hostname:
+// letters, digits, '-', '_' and '.', not starting with '-' or '.'. Anything
+// else (whitespace, '#', quotes, wildcards, control characters) could change
+// the meaning of the SSH config or of the ssh command line.
+func validateNodeName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty name")
 	}
+	if len(name) > 253 {
+		return fmt.Errorf("name longer than 253 characters")
+	}
+	if name[0] == '-' || name[0] == '.' {
+		return fmt.Errorf("name starts with %q", name[0])
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.':
+		default:
+			return fmt.Errorf("invalid character %q", r)
+		}
+	}
+	return nil
+}
 
-	// Read existing config
-	var existingConfig string
-	data, err := os.ReadFile(sshConfig)
-	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to read SSH config: %v", err)
+// checkSSHConfig reports problems in a parsed SSH config. It fails if the
+// scicom-helper markers are damaged, since we can't tell which lines are ours.
+func checkSSHConfig(path string, file *sshConfigFile) error {
+	for _, problem := range file.problems {
+		if !problem.fatal {
+			fmt.Printf("Warning: %s: %s\n", path, problem)
+		}
 	}
-	existingConfig = string(data)
 
-	// Remove old scicom-helper section if it exists
-	if strings.Contains(existingConfig, markerStart) {
-		fmt.Println("Removing existing scicom-helper configuration...")
-		existingConfig = removeSection(existingConfig, markerStart, markerEnd)
+	fatal := file.fatalProblems()
+	if len(fatal) == 0 {
+		return nil
 	}
+	var lines []string
+	for _, problem := range fatal {
+		lines = append(lines, "  "+problem.String())
+	}
+	return fmt.Errorf("cannot safely update %s:\n%s\n\nFix or remove the scicom-helper marker lines by hand and run update-nodes again", path, strings.Join(lines, "\n"))
+}
 
-	// Build new configuration section
-	var configBuilder strings.Builder
-	configBuilder.WriteString(markerStart)
-	configBuilder.WriteString("\n")
-	configBuilder.WriteString(fmt.Sprintf("# Auto-generated by scicom-helper\n"))
-	configBuilder.WriteString(fmt.Sprintf("# Last updated: %s\n", time.Now().Format("2006-01-02 15:04:05")))
-	configBuilder.WriteString(fmt.Sprintf("# Run 'scicom-helper' -> 'Teleport Update Nodes' to refresh\n"))
-	configBuilder.WriteString("\n")
+// lastUpdatedComment starts the only line of a generated block that
+// changes on every run
+const lastUpdatedComment = "# Last updated:"
+
+// stableContent renders the section without its "Last updated" line, for
+// deciding whether it needs to be rewritten
+func (s *sshSection) stableContent() string {
+	var out strings.Builder
+	for _, raw := range splitLines(s.String()) {
+		if !strings.HasPrefix(strings.TrimSpace(raw), lastUpdatedComment) {
+			out.WriteString(raw)
+		}
+	}
+	return out.String()
+}
 
-	// Add base Teleport configuration
-	configBuilder.WriteString(tshConfig)
-	configBuilder.WriteString("\n")
-
-	// Add wildcard patterns for Teleport-managed hosts
-	tshKeysDir := filepath.Join(home, ".tsh", "keys", teleportProxy)
-	configBuilder.WriteString("# Wildcard patterns for Teleport-managed hosts\n")
-
-	// Build host list: all nodes + wildcard domains
-	hostList := make([]string, len(nodes))
-	copy(hostList, nodes)
-	hostList = append(hostList, fmt.Sprintf("*.%s", teleportProxy))
-	hostList = append(hostList, teleportProxy)
-
-	configBuilder.WriteString(fmt.Sprintf("Host %s\n", strings.Join(hostList, " ")))
-	configBuilder.WriteString(fmt.Sprintf("    UserKnownHostsFile \"%s\"\n", toSSHPath(filepath.Join(home, ".tsh", "known_hosts"))))
-	configBuilder.WriteString(fmt.Sprintf("    IdentityFile \"%s\"\n", toSSHPath(filepath.Join(tshKeysDir, user))))
-	configBuilder.WriteString(fmt.Sprintf("    CertificateFile \"%s\"\n", toSSHPath(filepath.Join(tshKeysDir, user+"-ssh", teleportProxy+"-cert.pub"))))
-	configBuilder.WriteString("\n")
-
-	// For non-proxy hosts (all nodes and wildcard subdomains)
-	proxyHostList := make([]string, len(nodes))
-	copy(proxyHostList, nodes)
-	proxyHostList = append(proxyHostList, fmt.Sprintf("*.%s", teleportProxy))
-
-	configBuilder.WriteString(fmt.Sprintf("Host %s\n", strings.Join(proxyHostList, " ")))
-	configBuilder.WriteString("    Port 3022\n")
-	configBuilder.WriteString(fmt.Sprintf("    ProxyCommand \"tsh\" proxy ssh --cluster=%s --proxy=%s:443 %%r@%%h:%%p\n", teleportProxy, teleportProxy))
-	configBuilder.WriteString("\n")
-
-	// Add specific node entries
-	configBuilder.WriteString("# Specific node aliases\n")
-	for _, node := range nodes {
-		configBuilder.WriteString(fmt.Sprintf("Host %s\n", node))
-		configBuilder.WriteString(fmt.Sprintf("    HostName %s.%s\n", node, teleportProxy))
-		configBuilder.WriteString(fmt.Sprintf("    User %s\n", defaultUser))
-		configBuilder.WriteString("\n")
+// nodeAliases returns the aliases of the per-node Host blocks in a generated
+// section; a block's first pattern is its alias
+func (s *sshSection) nodeAliases() []string {
+	var aliases []string
+	for _, block := range s.blocks {
+		patterns := block.patterns()
+		if len(patterns) == 0 || strings.ContainsAny(patterns[0], "*?!") {
+			continue
+		}
+		for _, line := range block.lines {
+			if line.keyword == "hostname" {
+				aliases = append(aliases, patterns[0])
+				break
+			}
+		}
 	}
+	return aliases
+}
 
-	configBuilder.WriteString(markerEnd)
-	configBuilder.WriteString("\n")
+// nodeHosts returns the per-node Host blocks of a generated section of proxy
+// as aliases, with the node hostname taken from HostName
+func (s *sshSection) nodeHosts(proxy string) []nodeAlias {
+	var hosts []nodeAlias
+	for _, block := range s.blocks {
+		patterns := block.patterns()
+		if len(patterns) == 0 || strings.ContainsAny(patterns[0], "*?!") {
+			continue
+		}
+		for _, line := range block.lines {
+			if line.keyword == "hostname" && len(line.args) > 0 {
+				host := nodeAlias{Alias: patterns[0], Hostname: strings.TrimSuffix(line.args[0], "."+proxy)}
+				if len(patterns) > 1 {
+					host.Original = patterns[1]
+				}
+				hosts = append(hosts, host)
+				break
+			}
+		}
+	}
+	return hosts
+}
 
-	// Write updated config
-	newConfig := existingConfig + "\n" + configBuilder.String()
-	if err := os.WriteFile(sshConfig, []byte(newConfig), 0600); err != nil {
-		return fmt.Errorf("failed to write SSH config: %v", err)
+// diffNodeAliases returns the aliases added and removed since the previous run
+func diffNodeAliases(previous []string, current []nodeAlias) ([]string, []string) {
+	old := map[string]bool{}
+	for _, alias := range previous {
+		old[alias] = true
 	}
 
-	fmt.Println()
-	fmt.Println("=== Update Complete! ===")
-	fmt.Println()
-	fmt.Printf("Added %d node(s) to SSH config:\n", len(nodes))
-	for _, node := range nodes {
-		fmt.Printf("  - %s\n", node)
+	var added []string
+	now := map[string]bool{}
+	for _, alias := range current {
+		now[alias.Alias] = true
+		if !old[alias.Alias] {
+			added = append(added, alias.Alias)
+		}
 	}
-	fmt.Println()
-	fmt.Println("You can now:")
-	fmt.Println("  1. Connect via SSH: ssh <node-name>")
-	fmt.Println("  2. Use VS Code Remote-SSH extension to connect")
-	fmt.Println("  3. See these hosts in VS Code's Remote Explorer")
-	fmt.Println()
 
-	return nil
+	var removed []string
+	for _, alias := range previous {
+		if !now[alias] {
+			removed = append(removed, alias)
+		}
+	}
+	return added, removed
 }
 
-// removeSection removes a section between start and end markers from content
-func removeSection(content, start, end string) string {
-	startIdx := strings.Index(content, start)
-	if startIdx == -1 {
-		return content
-	}
+// buildTeleportSection generates the managed SSH config block of one cluster
+func buildTeleportSection(home string, state clusterState, aliases []nodeAlias) *sshSection {
+	proxy := state.cluster.Proxy
+	section := newSSHSection(state.cluster.Name)
 
-	endIdx := strings.Index(content[startIdx:], end)
-	if endIdx == -1 {
-		return content
-	}
+	section.addComment(fmt.Sprintf("Auto-generated by scicom-helper for %s", proxy))
+	section.addLines(fmt.Sprintf("%s %s\n", lastUpdatedComment, time.Now().Format("2006-01-02 15:04:05")))
+	section.addComment("Run 'scicom-helper update-nodes' to refresh")
+	section.addBlank()
 
-	// Include the end marker in removal
-	endIdx = startIdx + endIdx + len(end)
+	// Add base Teleport configuration
+	section.addLines(state.tshConfig + "\n")
+
+	// Per-node blocks map each alias to its Teleport hostname and set the
+	// login where it differs from the default below, plus any per-node
+	// ssh_directives. They come first, so their values win over the Match
+	// blocks.
+	nodes := map[string]tshNode{}
+	for _, node := range state.nodes {
+		nodes[node.Hostname] = node
+	}
 
-	// Remove the section and any trailing newlines
-	result := content[:startIdx] + content[endIdx:]
-	result = strings.TrimRight(result, "\n")
+	section.addComment("Teleport nodes")
+	for _, alias := range aliases {
+		if alias.Original != "" {
+			section.addHost(alias.Alias, alias.Original)
+		} else {
+			section.addHost(alias.Alias)
+		}
+		section.addDirective("HostName", fmt.Sprintf("%s.%s", alias.Hostname, proxy))
+		if alias.User != "" {
+			section.addDirective("User", alias.User)
+		}
+		node := nodes[alias.Hostname]
+		for _, directive := range extraDirectives(cfg, &node) {
+			section.addDirective(directive.keyword, directive.value)
+		}
+	}
+	section.addBlank()
+
+	// ssh_directives without nodes or labels apply to every node
+	if global := extraDirectives(cfg, nil); len(global) > 0 {
+		section.addComment("Settings from ssh_directives")
+		section.addMatch(fmt.Sprintf("host *.%s", proxy))
+		for _, directive := range global {
+			section.addDirective(directive.keyword, directive.value)
+		}
+		section.addBlank()
+	}
 
-	return result
+	// Everything else is declared once. Match host sees the HostName set
+	// above, so these apply to the aliases and to <node>.<proxy> alike.
+	tshKeysDir := filepath.Join(home, ".tsh", "keys", proxy)
+	section.addComment(fmt.Sprintf("Settings shared by all hosts of %s", proxy))
+	section.addMatch(fmt.Sprintf("host *.%s,%s", proxy, proxy))
+	section.addDirective("UserKnownHostsFile", fmt.Sprintf("\"%s\"", toSSHPath(filepath.Join(home, ".tsh", "known_hosts"))))
+	section.addDirective("IdentityFile", fmt.Sprintf("\"%s\"", toSSHPath(filepath.Join(tshKeysDir, state.user))))
+	section.addDirective("CertificateFile", fmt.Sprintf("\"%s\"", toSSHPath(filepath.Join(tshKeysDir, state.user+"-ssh", state.teleport+"-cert.pub"))))
+	section.addBlank()
+
+	// Nodes are reached through the proxy, the proxy itself directly
+	section.addMatch(fmt.Sprintf("host *.%s", proxy))
+	section.addDirective("Port", fmt.Sprintf("%d", state.cluster.SSHPort))
+	section.addDirective("ProxyCommand", tshClient.Proxy(state.teleport, proxy))
+	section.addDirective("User", state.defaultUser)
+	section.addBlank()
+
+	return section
 }
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// largeNodeCount is the cluster size the generated layout is designed for
+const largeNodeCount = 5000
+
+// largeCluster returns a cluster state and aliases for n generated nodes
+func largeCluster(n int) (clusterState, []nodeAlias) {
+	names := make([]string, n)
+	aliases := make([]nodeAlias, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("ip-10-%d-%d-%d", i/65536, i/256%256, i%256)
+		aliases[i] = nodeAlias{Alias: names[i], Hostname: names[i]}
+	}
+	return testClusterState(names...), aliases
+}
+
+func TestBuildTeleportSectionGolden(t *testing.T) {
+	useTestEnv(t, &fakeTsh{})
+	cfg.SSHDirectives = []SSHDirectiveRule{
+		{Nodes: []string{"gpu-*"}, Presets: []string{"jupyter"}},
+		{Presets: []string{"keepalive"}},
+	}
+
+	state := testClusterState("db-1", "gpu-1", "web-1")
+	aliases := []nodeAlias{
+		{Alias: "db-1", Hostname: "db-1", User: "postgres"},
+		{Alias: "gpu-1", Hostname: "gpu-1"},
+		{Alias: "prod-web-1", Hostname: "web-1", Original: "web-1"},
+	}
+	got := buildTeleportSection("/home/alice", state, aliases).stableContent()
+
+	golden := filepath.Join("testdata", "teleport_section.golden")
+	if *updateGolden {
+		if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := readTestFile(t, golden)
+	if got != want {
+		t.Errorf("generated section differs from %s (run go test -update to accept):\n%s", golden, unifiedDiff(golden, "generated", want, got))
+	}
+}
+
+func TestBuildTeleportSectionLarge(t *testing.T) {
+	home := useTestEnv(t, &fakeTsh{})
+	state, aliases := largeCluster(largeNodeCount)
+	section := buildTeleportSection(home, state, aliases)
+	content := section.String()
+
+	// Each node adds a two-line Host block (67 bytes here); nothing lists
+	// every node
+	const maxBytesPerNode = 80
+	if size := len(content); size > largeNodeCount*maxBytesPerNode {
+		t.Errorf("section for %d nodes is %d bytes, want at most %d", largeNodeCount, size, largeNodeCount*maxBytesPerNode)
+	}
+	longest := 0
+	for _, line := range strings.Split(content, "\n") {
+		if len(line) > longest {
+			longest = len(line)
+		}
+	}
+	if longest > 200 {
+		t.Errorf("longest line is %d characters, want at most 200", longest)
+	}
+	if got := len(section.nodeAliases()); got != largeNodeCount {
+		t.Errorf("section has %d node block(s), want %d", got, largeNodeCount)
+	}
+
+	if _, err := exec.LookPath("ssh"); err != nil {
+		return
+	}
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	last := aliases[len(aliases)-1]
+	options, err := sshEffectiveConfig(path, last.Alias)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"hostname": last.Hostname + "." + testProxy,
+		"port":     "3022",
+		"user":     "ubuntu",
+	}
+	for key, value := range want {
+		if options[key] != value {
+			t.Errorf("ssh -G %s: %s = %q, want %q", last.Alias, key, options[key], value)
+		}
+	}
+}
+
+func BenchmarkBuildTeleportSection(b *testing.B) {
+	saved := cfg
+	cfg = defaultConfig()
+	defer func() { cfg = saved }()
+
+	state, aliases := largeCluster(largeNodeCount)
+	b.ResetTimer()
+	size := 0
+	for i := 0; i < b.N; i++ {
+		size = len(buildTeleportSection("/home/alice", state, aliases).String())
+	}
+	b.ReportMetric(float64(size), "bytes/section")
+}
+
+func BenchmarkFindSSHConflicts(b *testing.B) {
+	saved := cfg
+	cfg = defaultConfig()
+	defer func() { cfg = saved }()
+
+	state, aliases := largeCluster(largeNodeCount)
+	generated := []*sshSection{buildTeleportSection("/home/alice", state, aliases)}
+	names := make([]string, len(aliases))
+	for i, alias := range aliases {
+		names[i] = alias.Alias
+	}
+	user := parseSSHConfig("Host github.com\n    User git\n\nHost *\n    ServerAliveInterval 60\n")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		findSSHConflicts(generated, user, names)
+	}
+}
@@ -0,0 +1,358 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testProxy = "teleport.example.com"
+
+// testClusterState returns the state of a cluster with the given node names
+func testClusterState(names ...string) clusterState {
+	state := clusterState{
+		cluster:     ClusterConfig{Name: "default", Proxy: testProxy, SSHPort: 3022},
+		teleport:    "example",
+		user:        "alice",
+		tshConfig:   "# Begin generated Teleport configuration\n# End generated Teleport configuration",
+		defaultUser: "ubuntu",
+	}
+	for _, name := range names {
+		state.nodes = append(state.nodes, tshNode{Hostname: name, Labels: map[string]string{"env": "prod"}})
+	}
+	state.accessible = state.nodes
+	return state
+}
+
+func TestBuildTeleportSection(t *testing.T) {
+	tests := []struct {
+		name       string
+		aliases    []nodeAlias
+		directives []SSHDirectiveRule
+		want       []string
+		notWant    []string
+	}{
+		{
+			name:    "plain node",
+			aliases: []nodeAlias{{Alias: "web-1", Hostname: "web-1"}},
+			want: []string{
+				"Host web-1\n    HostName web-1.teleport.example.com\n",
+				"Match host *.teleport.example.com,teleport.example.com\n",
+				"Match host *.teleport.example.com\n    Port 3022\n    ProxyCommand tsh proxy ssh --cluster=example --proxy=teleport.example.com:443 %r@%h:%p\n    User ubuntu\n",
+				"# Begin generated Teleport configuration\n",
+			},
+			notWant: []string{"    User root\n", "Settings from ssh_directives"},
+		},
+		{
+			name:    "alias from template keeps the node name",
+			aliases: []nodeAlias{{Alias: "prod-web-1", Hostname: "web-1", Original: "web-1"}},
+			want:    []string{"Host prod-web-1 web-1\n    HostName web-1.teleport.example.com\n"},
+		},
+		{
+			name:    "per-node login",
+			aliases: []nodeAlias{{Alias: "db-1", Hostname: "db-1", User: "postgres"}},
+			want:    []string{"Host db-1\n    HostName db-1.teleport.example.com\n    User postgres\n"},
+		},
+		{
+			name:    "per-node and global directives",
+			aliases: []nodeAlias{{Alias: "web-1", Hostname: "web-1"}},
+			directives: []SSHDirectiveRule{
+				{Labels: []string{"env=prod"}, Presets: []string{"jupyter"}},
+				{Directives: []string{"ServerAliveInterval 60"}},
+			},
+			want: []string{
+				"    HostName web-1.teleport.example.com\n    LocalForward 8888 localhost:8888\n",
+				"# Settings from ssh_directives\nMatch host *.teleport.example.com\n    ServerAliveInterval 60\n",
+			},
+		},
+		{
+			name:    "directives set for every host are not repeated per node",
+			aliases: []nodeAlias{{Alias: "web-1", Hostname: "web-1"}},
+			directives: []SSHDirectiveRule{
+				{Nodes: []string{"web-*"}, Directives: []string{"localforward 8888 localhost:8888", "ForwardAgent yes"}},
+				{Presets: []string{"jupyter"}},
+			},
+			want: []string{
+				"    HostName web-1.teleport.example.com\n    ForwardAgent yes\n\n",
+				"# Settings from ssh_directives\nMatch host *.teleport.example.com\n    LocalForward 8888 localhost:8888\n",
+			},
+			notWant: []string{"    localforward 8888"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := useTestEnv(t, &fakeTsh{})
+			cfg.SSHDirectives = tt.directives
+
+			var names []string
+			for _, alias := range tt.aliases {
+				names = append(names, alias.Hostname)
+			}
+			content := buildTeleportSection(home, testClusterState(names...), tt.aliases).String()
+
+			for _, want := range tt.want {
+				if !strings.Contains(content, want) {
+					t.Errorf("section is missing %q:\n%s", want, content)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(content, notWant) {
+					t.Errorf("section should not contain %q:\n%s", notWant, content)
+				}
+			}
+		})
+	}
+}
+
+func TestStableContentIgnoresTimestamp(t *testing.T) {
+	a := newSSHSection("default")
+	a.addLines(lastUpdatedComment + " 2024-01-01 10:00:00\n")
+	a.addHost("web-1")
+	b := newSSHSection("default")
+	b.addLines(lastUpdatedComment + " 2024-06-01 12:30:00\n")
+	b.addHost("web-1")
+
+	if a.stableContent() != b.stableContent() {
+		t.Errorf("stableContent differs only by timestamp:\n%s\n%s", a.stableContent(), b.stableContent())
+	}
+}
+
+func TestValidateNodeName(t *testing.T) {
+	valid := []string{"web-1", "db_2.internal", "A1"}
+	invalid := []string{"", "-web", ".hidden", "web 1", "web#1", "web*", `we"b`, "web\n"}
+	for _, name := range valid {
+		if err := validateNodeName(name); err != nil {
+			t.Errorf("validateNodeName(%q) = %v, want nil", name, err)
+		}
+	}
+	for _, name := range invalid {
+		if err := validateNodeName(name); err == nil {
+			t.Errorf("validateNodeName(%q) = nil, want an error", name)
+		}
+	}
+}
+
+// newTestCluster returns a fake with one logged-in cluster at the default proxy
+func newTestCluster(names ...string) *fakeTsh {
+	proxy := defaultConfig().Proxy
+	fake := &fakeTsh{
+		profiles: map[string]*tshStatus{
+			proxy: {Username: "alice", Cluster: "scicom", Logins: []string{"root", "ubuntu"}},
+		},
+		active:  proxy,
+		nodes:   map[string][]tshNode{},
+		configs: map[string]string{proxy: "# Teleport config\n"},
+	}
+	for _, name := range names {
+		fake.nodes[proxy] = append(fake.nodes[proxy], tshNode{Hostname: name})
+	}
+	return fake
+}
+
+func TestUpdateNodes(t *testing.T) {
+	fake := newTestCluster("web-1", "web-2")
+	home := useTestEnv(t, fake)
+
+	sshConfig := filepath.Join(home, ".ssh", "config")
+	includePath := sshIncludePath(filepath.Join(home, ".ssh"))
+	userConfig := "Host github.com\n    User git\n"
+	if err := os.MkdirAll(filepath.Dir(sshConfig), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sshConfig, []byte(userConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := updateNodes(writeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	main := readTestFile(t, sshConfig)
+	if !strings.HasPrefix(main, includeComment+"\nInclude "+includeRelPath+"\n") {
+		t.Errorf("~/.ssh/config does not start with the Include:\n%s", main)
+	}
+	if !strings.Contains(main, userConfig) {
+		t.Errorf("~/.ssh/config lost the user's settings:\n%s", main)
+	}
+
+	generated := readTestFile(t, includePath)
+	for _, want := range []string{
+		"Host web-1\n    HostName web-1.teleport-iam.aies.scicom.dev\n",
+		"Host web-2\n    HostName web-2.teleport-iam.aies.scicom.dev\n",
+		"    User ubuntu\n",
+		"# Teleport config\n",
+	} {
+		if !strings.Contains(generated, want) {
+			t.Errorf("generated config is missing %q:\n%s", want, generated)
+		}
+	}
+
+	manifest, err := readBackupManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Backups) != 1 || manifest.Backups[0].Source != sshConfig {
+		t.Errorf("expected one backup of %s, got %+v", sshConfig, manifest.Backups)
+	}
+
+	// A second run with the same nodes leaves both files alone
+	if err := updateNodes(writeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if again := readTestFile(t, includePath); again != generated {
+		t.Errorf("unchanged nodes rewrote the generated config:\n%s", unifiedDiff("before", "after", generated, again))
+	}
+
+	// New nodes are added and recorded in the inventory
+	fake.nodes[cfg.Proxy] = append(fake.nodes[cfg.Proxy], tshNode{Hostname: "web-3"})
+	if err := updateNodes(writeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if generated := readTestFile(t, includePath); !strings.Contains(generated, "Host web-3\n") {
+		t.Errorf("web-3 was not added:\n%s", generated)
+	}
+	inventory, err := loadInventory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(inventory.Clusters[defaultClusterName].Nodes); got != 3 {
+		t.Errorf("inventory has %d node(s), want 3", got)
+	}
+}
+
+func TestUpdateNodesKeptClusterAliases(t *testing.T) {
+	fake := &fakeTsh{
+		nodes: map[string][]tshNode{
+			"a.example.com": {{Hostname: "web-1"}},
+			"b.example.com": {{Hostname: "web-1"}},
+		},
+		configs: map[string]string{},
+	}
+	home := useTestEnv(t, fake)
+	cfg.Clusters = []ClusterConfig{{Name: "a", Proxy: "a.example.com"}, {Name: "b", Proxy: "b.example.com"}}
+	includePath := sshIncludePath(filepath.Join(home, ".ssh"))
+
+	// loginOnly runs update-nodes logged in to proxy only and returns the
+	// HostName of every alias in the generated config
+	loginOnly := func(proxy string) map[string]string {
+		t.Helper()
+		fake.profiles = map[string]*tshStatus{proxy: {Username: "alice", Cluster: proxy, Logins: []string{"ubuntu"}}}
+		fake.active = proxy
+		captureOutput(t, func() {
+			if err := updateNodes(writeOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		})
+
+		hosts := map[string]string{}
+		for _, section := range parseSSHConfig(readTestFile(t, includePath)).removeSections() {
+			for _, block := range section.blocks {
+				for _, line := range block.lines {
+					if line.keyword != "hostname" {
+						continue
+					}
+					for _, alias := range block.patterns() {
+						if previous, ok := hosts[alias]; ok {
+							t.Errorf("Host %s is defined twice, for %s and %s", alias, previous, line.args[0])
+						}
+						hosts[alias] = line.args[0]
+					}
+				}
+			}
+		}
+		return hosts
+	}
+
+	loginOnly("a.example.com")
+	hosts := loginOnly("b.example.com")
+	if hosts["web-1"] != "web-1.a.example.com" || hosts["b.web-1"] != "web-1.b.example.com" {
+		t.Errorf("after logging in to b only, aliases are %v", hosts)
+	}
+
+	// Once both clusters are known, each node keeps a namespaced alias
+	hosts = loginOnly("a.example.com")
+	if hosts["a.web-1"] != "web-1.a.example.com" || hosts["b.web-1"] != "web-1.b.example.com" {
+		t.Errorf("after logging in to a only again, aliases are %v", hosts)
+	}
+}
+
+func TestUpdateNodesNotLoggedIn(t *testing.T) {
+	home := useTestEnv(t, &fakeTsh{})
+	if err := updateNodes(writeOptions{}); err == nil {
+		t.Fatal("expected an error when not logged in")
+	}
+	if _, err := os.Stat(filepath.Join(home, ".ssh", "config")); !os.IsNotExist(err) {
+		t.Errorf("~/.ssh/config was created without a login: %v", err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestUpdateNodesDryRun(t *testing.T) {
+	home := useTestEnv(t, newTestCluster("web-1"))
+	if err := updateNodes(writeOptions{dryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(home, ".ssh")); !os.IsNotExist(err) {
+		t.Errorf("dry run created ~/.ssh: %v", err)
+	}
+}
+
+// captureOutput returns what f prints to stdout
+func captureOutput(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	defer func() { os.Stdout = stdout }()
+	f()
+	w.Close()
+	os.Stdout = stdout
+	return <-done
+}
+
+func TestUpdateNodesReportsFilteredChanges(t *testing.T) {
+	fake := newTestCluster("web-1", "gpu-1")
+	useTestEnv(t, fake)
+	cfg.ExcludeNodes = []string{"gpu-*"}
+	if err := updateNodes(writeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new grant hidden by exclude_nodes doesn't change the generated file
+	fake.nodes[cfg.Proxy] = append(fake.nodes[cfg.Proxy], tshNode{Hostname: "gpu-7"})
+	var err error
+	output := captureOutput(t, func() { err = updateNodes(writeOptions{}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "No changes") || !strings.Contains(output, "+ gpu-7") {
+		t.Errorf("gpu-7 was not reported:\n%s", output)
+	}
+
+	inventory, err := loadInventory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(inventory.Clusters[defaultClusterName].Nodes); got != 3 {
+		t.Errorf("inventory has %d node(s), want 3", got)
+	}
+}
@@ -4,39 +4,122 @@ import (
 	"bytes"
 	"fmt"
 	"os/exec"
-	"runtime"
 	"strings"
+	"time"
 )
 
 // isTshInstalled checks if the tsh command is available
 func isTshInstalled() bool {
-	cmdName := "which"
-	if runtime.GOOS == "windows" {
-		cmdName = "where"
-	}
-	cmd := exec.Command(cmdName, "tsh")
-	return cmd.Run() == nil
+	return tshClient.Installed()
 }
 
-// isTeleportLoggedIn checks if the user is logged in to Teleport
+// isTeleportLoggedIn checks if the user is logged in to the active cluster
 func isTeleportLoggedIn() bool {
-	cmd := exec.Command("tsh", "status")
-	return cmd.Run() == nil
+	_, err := tshClient.Status(cfg.activeCluster().Proxy)
+	return err == nil
 }
 
-// getTeleportUser returns the current Teleport user
+// getTeleportUser returns the current Teleport user on the active cluster
 func getTeleportUser() (string, error) {
-	cmd := exec.Command("tsh", "status")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-
-	if err := cmd.Run(); err != nil {
+	status, err := tshClient.Status(cfg.activeCluster().Proxy)
+	if err != nil {
 		return "", err
 	}
+	if status.Username == "" {
+		return "", fmt.Errorf("could not find user in tsh status output")
+	}
+	return status.Username, nil
+}
+
+// parseTshStatusText parses the human-readable output of `tsh status` and
+// returns the profile for proxy, or the active profile if proxy is empty.
+// It is only used as a fallback for tsh versions without JSON output.
+func parseTshStatusText(output, proxy string) (*tshStatus, error) {
+	profiles := splitStatusProfiles(output)
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("not logged in to Teleport")
+	}
 
+	for _, profile := range profiles {
+		if proxy == "" && profile.active || proxy != "" && profile.url != "" && proxyHost(profile.url) == proxyHost(proxy) {
+			return &tshStatus{
+				ProfileURL: profile.url,
+				Username:   parseStatusUser(profile.text),
+				Cluster:    parseStatusField(profile.text, "Cluster:"),
+				Roles:      splitList(parseStatusField(profile.text, "Roles:")),
+				Logins:     parseStatusLogins(profile.text),
+				ValidUntil: parseStatusValidUntil(profile.text),
+			}, nil
+		}
+	}
+	if proxy == "" {
+		return nil, fmt.Errorf("no active Teleport profile")
+	}
+	return nil, fmt.Errorf("not logged in to %s", proxy)
+}
+
+// statusProfile is one profile in the text output of `tsh status`
+type statusProfile struct {
+	url    string
+	active bool // listed with a leading ">"
+	text   string
+}
+
+// splitStatusProfiles splits `tsh status` output at each "Profile URL:" line.
+// Output without one, as printed by very old tsh versions, is a single
+// active profile with an unknown URL.
+func splitStatusProfiles(output string) []statusProfile {
+	var profiles []statusProfile
+	var current *statusProfile
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(strings.TrimSpace(strings.TrimPrefix(trimmed, ">")), "Profile URL:") {
+			_, url, _ := strings.Cut(trimmed, "Profile URL:")
+			profiles = append(profiles, statusProfile{
+				url:    strings.TrimSpace(url),
+				active: strings.HasPrefix(trimmed, ">"),
+			})
+			current = &profiles[len(profiles)-1]
+		}
+		if current != nil {
+			current.text += line + "\n"
+		}
+	}
+
+	if len(profiles) == 0 && parseStatusUser(output) != "" {
+		return []statusProfile{{active: true, text: output}}
+	}
+	return profiles
+}
+
+// parseStatusField returns the value of the first "Name: value" line
+func parseStatusField(output, name string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), name); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// parseStatusValidUntil reads the certificate expiry from the "Valid until:"
+// line, e.g. "2024-01-01 20:00:00 +0000 UTC [valid for 11h0m0s]"
+func parseStatusValidUntil(output string) time.Time {
+	fields := strings.Fields(parseStatusField(output, "Valid until:"))
+	if len(fields) < 4 {
+		return time.Time{}
+	}
+	validUntil, err := time.Parse("2006-01-02 15:04:05 -0700 MST", strings.Join(fields[:4], " "))
+	if err != nil {
+		return time.Time{}
+	}
+	return validUntil
+}
+
+// parseStatusUser finds the user in `tsh status` output
+func parseStatusUser(output string) string {
 	// Parse output to find User: line
 	// Format can be "User: username" or "> Profile URL:  user@cluster"
-	output := out.String()
 	lines := strings.Split(output, "\n")
 
 	for _, line := range lines {
@@ -46,7 +129,7 @@ func getTeleportUser() (string, error) {
 		if strings.HasPrefix(trimmed, "User:") {
 			parts := strings.Fields(trimmed)
 			if len(parts) >= 2 {
-				return parts[1], nil
+				return parts[1]
 			}
 		}
 
@@ -61,7 +144,7 @@ func getTeleportUser() (string, error) {
 				if lastSlash >= 0 {
 					user := beforeAt[lastSlash+1:]
 					if user != "" {
-						return user, nil
+						return user
 					}
 				}
 			}
@@ -73,76 +156,19 @@ func getTeleportUser() (string, error) {
 			if len(parts) >= 2 {
 				user := strings.TrimSpace(parts[1])
 				if user != "" {
-					return user, nil
+					return user
 				}
 			}
 		}
 	}
 
-	return "", fmt.Errorf("could not find user in tsh status output. Output was:\n%s", output)
-}
-
-// getTeleportNodes returns a list of available Teleport nodes
-func getTeleportNodes() ([]string, error) {
-	cmd := exec.Command("tsh", "ls", "--format=names")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-
-	if err := cmd.Run(); err != nil {
-		return nil, err
-	}
-
-	nodes := []string{}
-	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line != "" {
-			nodes = append(nodes, line)
-		}
-	}
-
-	return nodes, nil
+	return ""
 }
 
-// pickDefaultLogin picks the best default login from available logins
-// Priority: ubuntu > root > first available
-func pickDefaultLogin(logins []string) string {
-	if len(logins) == 0 {
-		return "ubuntu"
-	}
-
-	// Check for ubuntu first
-	for _, login := range logins {
-		if login == "ubuntu" {
-			return "ubuntu"
-		}
-	}
-
-	// Check for root second
-	for _, login := range logins {
-		if login == "root" {
-			return "root"
-		}
-	}
-
-	// Return first available
-	return logins[0]
-}
-
-// getAllLogins returns all logins from tsh status (all roles combined)
-func getAllLogins() ([]string, error) {
-	cmd := exec.Command("tsh", "status")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-
-	if err := cmd.Run(); err != nil {
-		return nil, err
-	}
-
-	output := out.String()
+// parseStatusLogins finds the "Logins:" line in `tsh status` output
+func parseStatusLogins(output string) []string {
 	lines := strings.Split(output, "\n")
 
-	// Look for "Logins:" line
 	for _, line := range lines {
 		trimmed := strings.TrimSpace(line)
 		if strings.HasPrefix(trimmed, "Logins:") {
@@ -152,73 +178,41 @@ func getAllLogins() ([]string, error) {
 				loginList := strings.Split(loginsStr, ",")
 				logins := []string{}
 				for _, login := range loginList {
-					login = strings.TrimSpace(login)
-					if login != "" {
-						logins = append(logins, login)
-					}
+					logins = append(logins, strings.TrimSpace(login))
 				}
-				return logins, nil
+				return filterLogins(logins)
 			}
 		}
 	}
 
-	return nil, fmt.Errorf("no logins found")
+	return nil
 }
 
-// testLoginAccess tests if a specific login works for a node
-func testLoginAccess(login, nodeName string) bool {
-	// Use a quick SSH test command with short timeout
-	// The "exit" command will close immediately if login is allowed
-	cmd := exec.Command("tsh", "ssh", fmt.Sprintf("%s@%s", login, nodeName), "exit")
-
-	// Set a timeout context (2 seconds should be enough)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
-
-	// If command succeeds, login is allowed
-	// If it fails with "access denied" or similar, login is not allowed
-	if err != nil {
-		// Check if error is due to access denial
-		stderrStr := stderr.String()
-		if strings.Contains(stderrStr, "access denied") ||
-		   strings.Contains(stderrStr, "permission denied") ||
-		   strings.Contains(stderrStr, "not allowed") {
-			return false
-		}
-		// Other errors might be temporary, so we'll include the login
-		return true
-	}
-
-	return true
+// getTeleportNodes returns a list of available nodes on the active cluster
+func getTeleportNodes() ([]tshNode, error) {
+	return tshClient.Ls(cfg.activeCluster().Proxy)
 }
 
-// getNodeLogins returns logins that actually work for the specific node
-func getNodeLogins(nodeName string) ([]string, error) {
-	// Get all possible logins from tsh status
-	allLogins, err := getAllLogins()
-	if err != nil {
-		// Fall back to defaults if we can't get logins
-		return []string{"ubuntu", "root"}, nil
-	}
-
-	// Test each login to see which ones work for this node
-	validLogins := []string{}
-
-	for _, login := range allLogins {
-		if testLoginAccess(login, nodeName) {
-			validLogins = append(validLogins, login)
+// pickDefaultLogin picks the best default login from available logins
+// Priority: the configured login_priority (ubuntu > root by default) > first available
+func pickDefaultLogin(logins []string) string {
+	if len(logins) == 0 {
+		if len(cfg.LoginPriority) > 0 {
+			return cfg.LoginPriority[0]
 		}
+		return "ubuntu"
 	}
 
-	// If no logins passed the test, return all of them anyway
-	// (the test might have failed for other reasons)
-	if len(validLogins) == 0 {
-		return allLogins, nil
+	for _, preferred := range cfg.LoginPriority {
+		for _, login := range logins {
+			if login == preferred {
+				return login
+			}
+		}
 	}
 
-	return validLogins, nil
+	// Return first available
+	return logins[0]
 }
 
 // runCommand executes a command and returns its output
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testStatusText = `> Profile URL:        https://teleport.example.com:443
+  Logged in as:       alice
+  Cluster:            example
+  Roles:              access, editor
+  Logins:             ubuntu, root, -teleport-nologin-1234
+  Kubernetes:         disabled
+  Valid until:        2030-01-01 20:00:00 +0000 UTC [valid for 12h0m0s]
+
+  Profile URL:        https://other.example.com:443
+  Logged in as:       alice@example.com
+  Cluster:            other
+  Roles:              access
+  Logins:             admin
+  Valid until:        2030-01-02 08:00:00 +0000 UTC [valid for 24h0m0s]
+`
+
+func TestParseTshStatusText(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string
+		proxy       string
+		wantUser    string
+		wantCluster string
+		wantLogins  []string
+		wantErr     bool
+	}{
+		{
+			name:        "active profile",
+			output:      testStatusText,
+			proxy:       "",
+			wantUser:    "alice",
+			wantCluster: "example",
+			wantLogins:  []string{"ubuntu", "root"},
+		},
+		{
+			name:        "active profile by proxy",
+			output:      testStatusText,
+			proxy:       "teleport.example.com",
+			wantUser:    "alice",
+			wantCluster: "example",
+			wantLogins:  []string{"ubuntu", "root"},
+		},
+		{
+			name:        "inactive profile by proxy",
+			output:      testStatusText,
+			proxy:       "other.example.com:443",
+			wantUser:    "alice@example.com",
+			wantCluster: "other",
+			wantLogins:  []string{"admin"},
+		},
+		{
+			name:    "not logged in to proxy",
+			output:  testStatusText,
+			proxy:   "missing.example.com",
+			wantErr: true,
+		},
+		{
+			name:       "output without profile URL",
+			output:     "User: bob\nLogins: admin\n",
+			proxy:      "",
+			wantUser:   "bob",
+			wantLogins: []string{"admin"},
+		},
+		{
+			name:    "output without profile URL for a proxy",
+			output:  "User: bob\nLogins: admin\n",
+			proxy:   "teleport.example.com",
+			wantErr: true,
+		},
+		{
+			name:       "user in profile URL",
+			output:     "> Profile URL:  https://proxy/web/cluster/carol@cluster\n",
+			proxy:      "",
+			wantUser:   "carol",
+			wantLogins: nil,
+		},
+		{
+			name:    "not logged in",
+			output:  "Not logged in.\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := parseTshStatusText(tt.output, tt.proxy)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.Username != tt.wantUser || status.Cluster != tt.wantCluster {
+				t.Errorf("got user %q cluster %q, want %q %q", status.Username, status.Cluster, tt.wantUser, tt.wantCluster)
+			}
+			if !reflect.DeepEqual(status.Logins, tt.wantLogins) {
+				t.Errorf("Logins = %q, want %q", status.Logins, tt.wantLogins)
+			}
+		})
+	}
+}
+
+func TestParseTshStatusTextValidUntil(t *testing.T) {
+	status, err := parseTshStatusText(testStatusText, "other.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2030, 1, 2, 8, 0, 0, 0, time.UTC)
+	if !status.ValidUntil.Equal(want) {
+		t.Errorf("ValidUntil = %v, want %v", status.ValidUntil, want)
+	}
+	if !reflect.DeepEqual(status.Roles, []string{"access"}) {
+		t.Errorf("Roles = %q, want [access]", status.Roles)
+	}
+}
+
+func TestPickDefaultLogin(t *testing.T) {
+	useTestEnv(t, &fakeTsh{})
+
+	tests := []struct {
+		logins []string
+		want   string
+	}{
+		{[]string{"root", "ubuntu"}, "ubuntu"},
+		{[]string{"root", "admin"}, "root"},
+		{[]string{"admin", "deploy"}, "admin"},
+		{nil, "ubuntu"},
+	}
+	for _, tt := range tests {
+		if got := pickDefaultLogin(tt.logins); got != tt.want {
+			t.Errorf("pickDefaultLogin(%q) = %q, want %q", tt.logins, got, tt.want)
+		}
+	}
+}
@@ -6,48 +6,94 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+
+	"github.com/spf13/cobra"
 )
 
+var configureEditorsCmd = &cobra.Command{
+	Use:   "configure-editors",
+	Short: "Configure VS Code, Cursor and other editors for Teleport Remote-SSH",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return configureVSCode(writeOptions{dryRun: configureEditorsDryRun})
+	},
+}
+
+var configureEditorsDryRun bool
+
+func init() {
+	configureEditorsCmd.Flags().BoolVar(&configureEditorsDryRun, "dry-run", false, "print a diff of the settings changes without writing them")
+	rootCmd.AddCommand(configureEditorsCmd)
+}
+
 // editorConfig represents an editor's settings configuration
 type editorConfig struct {
 	name         string
 	settingsPath string
 }
 
-// getEditorSettingsPaths returns settings paths for VS Code and Cursor
+// knownEditors maps editor names usable in the config file to their
+// display name and the application directory holding User/settings.json
+var knownEditors = map[string]struct {
+	displayName string
+	appDir      string
+}{
+	"vscode":          {displayName: "VS Code", appDir: "Code"},
+	"vscode-insiders": {displayName: "VS Code Insiders", appDir: "Code - Insiders"},
+	"vscodium":        {displayName: "VSCodium", appDir: "VSCodium"},
+	"cursor":          {displayName: "Cursor", appDir: "Cursor"},
+	"windsurf":        {displayName: "Windsurf", appDir: "Windsurf"},
+}
+
+// knownEditorNames returns the sorted editor names accepted in the config file
+func knownEditorNames() []string {
+	names := make([]string, 0, len(knownEditors))
+	for name := range knownEditors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getEditorSettingsPaths returns settings paths for the editors listed in the config
 func getEditorSettingsPaths(home string) []editorConfig {
-	var editors []editorConfig
+	var baseDir string
 
 	if runtime.GOOS == "windows" {
-		appData := os.Getenv("APPDATA")
-		if appData != "" {
-			editors = []editorConfig{
-				{name: "VS Code", settingsPath: filepath.Join(appData, "Code", "User", "settings.json")},
-				{name: "Cursor", settingsPath: filepath.Join(appData, "Cursor", "User", "settings.json")},
-			}
+		baseDir = os.Getenv("APPDATA")
+		if baseDir == "" {
+			return nil
 		}
 	} else if runtime.GOOS == "darwin" {
-		editors = []editorConfig{
-			{name: "VS Code", settingsPath: filepath.Join(home, "Library", "Application Support", "Code", "User", "settings.json")},
-			{name: "Cursor", settingsPath: filepath.Join(home, "Library", "Application Support", "Cursor", "User", "settings.json")},
-		}
+		baseDir = filepath.Join(home, "Library", "Application Support")
 	} else {
 		// Linux
-		editors = []editorConfig{
-			{name: "VS Code", settingsPath: filepath.Join(home, ".config", "Code", "User", "settings.json")},
-			{name: "Cursor", settingsPath: filepath.Join(home, ".config", "Cursor", "User", "settings.json")},
+		baseDir = filepath.Join(home, ".config")
+	}
+
+	var editors []editorConfig
+	for _, name := range cfg.Editors {
+		known, ok := knownEditors[name]
+		if !ok {
+			continue
 		}
+		editors = append(editors, editorConfig{
+			name:         known.displayName,
+			settingsPath: filepath.Join(baseDir, known.appDir, "User", "settings.json"),
+		})
 	}
 
 	return editors
 }
 
-// configureEditor configures a specific editor for Teleport compatibility
-func configureEditor(editor editorConfig) error {
+// configureEditor configures a specific editor for Teleport compatibility and
+// reports what was done with its settings file
+func configureEditor(editor editorConfig, opts writeOptions) (changeResult, error) {
 	// Check if editor settings directory exists
 	editorDir := filepath.Dir(editor.settingsPath)
 	if _, err := os.Stat(editorDir); os.IsNotExist(err) {
-		return fmt.Errorf("%s not found (directory doesn't exist)", editor.name)
+		return changeUnchanged, fmt.Errorf("%s not found (directory doesn't exist)", editor.name)
 	}
 
 	// Read existing settings or create empty map
@@ -59,12 +105,12 @@ func configureEditor(editor editorConfig) error {
 			settings = make(map[string]interface{})
 			fmt.Printf("Creating new %s settings file...\n", editor.name)
 		} else {
-			return fmt.Errorf("failed to read %s settings: %v", editor.name, err)
+			return changeUnchanged, fmt.Errorf("failed to read %s settings: %v", editor.name, err)
 		}
 	} else {
 		// Parse existing settings
 		if err := json.Unmarshal(data, &settings); err != nil {
-			return fmt.Errorf("failed to parse %s settings: %v", editor.name, err)
+			return changeUnchanged, fmt.Errorf("failed to parse %s settings: %v", editor.name, err)
 		}
 		fmt.Printf("Found existing %s settings\n", editor.name)
 	}
@@ -74,17 +120,7 @@ func configureEditor(editor editorConfig) error {
 		if boolVal, ok := val.(bool); ok && !boolVal {
 			fmt.Printf("✓ %s already configured correctly for Teleport\n", editor.name)
 			fmt.Println("  remote.SSH.useLocalServer = false")
-			return nil
-		}
-	}
-
-	// Backup existing settings
-	if len(data) > 0 {
-		backupPath := editor.settingsPath + ".backup"
-		if err := os.WriteFile(backupPath, data, 0644); err != nil {
-			fmt.Printf("Warning: Failed to create backup: %v\n", err)
-		} else {
-			fmt.Printf("Backed up existing settings to: %s\n", backupPath)
+			return changeUnchanged, nil
 		}
 	}
 
@@ -94,21 +130,45 @@ func configureEditor(editor editorConfig) error {
 	// Write updated settings
 	updatedData, err := json.MarshalIndent(settings, "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to marshal settings: %v", err)
+		return changeUnchanged, fmt.Errorf("failed to marshal settings: %v", err)
 	}
 
-	if err := os.WriteFile(editor.settingsPath, updatedData, 0644); err != nil {
-		return fmt.Errorf("failed to write %s settings: %v", editor.name, err)
+	change := fileChange{
+		path:    editor.settingsPath,
+		oldData: data,
+		newData: updatedData,
+		perm:    0644,
+		backup: func() error {
+			if len(data) == 0 {
+				return nil
+			}
+
+			// Backup existing settings
+			entry, err := saveBackup(editor.settingsPath, data, 0644, "configure-editors")
+			if err != nil {
+				return fmt.Errorf("failed to create backup: %v", err)
+			}
+			fmt.Printf("Backed up existing settings (backup %s)\n", entry.ID)
+			return nil
+		},
+	}
+
+	result, err := applyFileChange(change, opts)
+	if err != nil {
+		return changeUnchanged, fmt.Errorf("failed to write %s settings: %v", editor.name, err)
+	}
+	if result != changeWritten {
+		return result, nil
 	}
 
 	fmt.Printf("✓ %s configured successfully\n", editor.name)
 	fmt.Println("  Set remote.SSH.useLocalServer = false")
 
-	return nil
+	return changeWritten, nil
 }
 
 // configureVSCode sets up VS Code and Cursor settings for Teleport compatibility
-func configureVSCode() error {
+func configureVSCode(opts writeOptions) error {
 	fmt.Println("\n=== Configure Editors for Teleport ===")
 	fmt.Println()
 
@@ -118,23 +178,33 @@ func configureVSCode() error {
 		return fmt.Errorf("failed to get home directory: %v", err)
 	}
 
+	unlock, err := acquireLock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// Get editor settings paths
 	editors := getEditorSettingsPaths(home)
 
-	// Track which editors we successfully configured
+	// Track which editors we successfully configured or the user skipped
 	configuredEditors := []string{}
+	skippedEditors := []string{}
 	notFoundEditors := []string{}
 
 	// Try to configure each editor
 	for _, editor := range editors {
 		fmt.Printf("Checking for %s...\n", editor.name)
-		if err := configureEditor(editor); err != nil {
+		result, err := configureEditor(editor, opts)
+		if err != nil {
 			if os.IsNotExist(err) || filepath.Dir(editor.settingsPath) == "" {
 				notFoundEditors = append(notFoundEditors, editor.name)
 				fmt.Printf("  %s not found (not installed or not run yet)\n", editor.name)
 			} else {
 				fmt.Printf("  Warning: Failed to configure %s: %v\n", editor.name, err)
 			}
+		} else if result == changeDeclined {
+			skippedEditors = append(skippedEditors, editor.name)
 		} else {
 			configuredEditors = append(configuredEditors, editor.name)
 		}
@@ -142,19 +212,35 @@ func configureVSCode() error {
 	}
 
 	// Print summary
-	if len(configuredEditors) == 0 {
-		return fmt.Errorf("no editors found. Please install VS Code or Cursor and run it at least once")
+	if len(configuredEditors) == 0 && len(skippedEditors) == 0 {
+		return fmt.Errorf("no editors found. Please install VS Code or Cursor and run it at least once, or check the editors setting")
+	}
+
+	if opts.dryRun {
+		fmt.Printf("Dry run: checked %d editor(s), no settings were modified\n", len(configuredEditors))
+		fmt.Println()
+		return nil
 	}
 
 	fmt.Println("=== Editor Configuration Complete! ===")
 	fmt.Println()
-	fmt.Printf("✓ Configured %d editor(s):\n", len(configuredEditors))
-	for _, name := range configuredEditors {
-		fmt.Printf("  - %s\n", name)
+	if len(configuredEditors) > 0 {
+		fmt.Printf("✓ Configured %d editor(s):\n", len(configuredEditors))
+		for _, name := range configuredEditors {
+			fmt.Printf("  - %s\n", name)
+		}
+	}
+	if len(skippedEditors) > 0 {
+		fmt.Printf("Skipped %d editor(s); their settings were not changed:\n", len(skippedEditors))
+		for _, name := range skippedEditors {
+			fmt.Printf("  - %s\n", name)
+		}
 	}
 	fmt.Println()
 	fmt.Println("This setting is required for Teleport SSH connections.")
-	fmt.Println("Restart your editor(s) for changes to take effect.")
+	if len(configuredEditors) > 0 {
+		fmt.Println("Restart your editor(s) for changes to take effect.")
+	}
 	fmt.Println()
 
 	return nil
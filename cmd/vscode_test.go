@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigureEditor(t *testing.T) {
+	home := useTestEnv(t, &fakeTsh{})
+	settings := filepath.Join(home, ".config", "Code", "User", "settings.json")
+	if err := os.MkdirAll(filepath.Dir(settings), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(settings, []byte(`{"editor.fontSize": 14}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	editor := editorConfig{name: "VS Code", settingsPath: settings}
+	if result, err := configureEditor(editor, writeOptions{}); err != nil || result != changeWritten {
+		t.Fatalf("configureEditor() = %v, %v, want written", result, err)
+	}
+	got := readTestFile(t, settings)
+	if !strings.Contains(got, `"remote.SSH.useLocalServer": false`) || !strings.Contains(got, `"editor.fontSize": 14`) {
+		t.Errorf("unexpected settings:\n%s", got)
+	}
+}
+
+func TestConfigureEditorBackupFailure(t *testing.T) {
+	home := useTestEnv(t, &fakeTsh{})
+	settings := filepath.Join(home, ".config", "Code", "User", "settings.json")
+	if err := os.MkdirAll(filepath.Dir(settings), 0700); err != nil {
+		t.Fatal(err)
+	}
+	original := `{"editor.fontSize": 14}`
+	if err := os.WriteFile(settings, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A state directory that can't be created makes the backup fail
+	blocker := filepath.Join(home, "not-a-directory")
+	if err := os.WriteFile(blocker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XDG_STATE_HOME", blocker)
+
+	editor := editorConfig{name: "VS Code", settingsPath: settings}
+	if _, err := configureEditor(editor, writeOptions{}); err == nil {
+		t.Fatal("expected an error when the backup fails")
+	}
+	if got := readTestFile(t, settings); got != original {
+		t.Errorf("settings were overwritten without a backup:\n%s", got)
+	}
+}
+
+func TestConfigureVSCodeDeclined(t *testing.T) {
+	home := useTestEnv(t, &fakeTsh{})
+	cfg.Editors = []string{"vscode", "cursor"}
+	editors := getEditorSettingsPaths(home)
+	if len(editors) != 2 {
+		t.Skip("no editor settings directory on this platform")
+	}
+	original := `{"editor.fontSize": 14}`
+	for _, editor := range editors {
+		if err := os.MkdirAll(filepath.Dir(editor.settingsPath), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(editor.settingsPath, []byte(original), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Say no for VS Code and yes for Cursor
+	confirm := confirmChange
+	confirmChange = func(path string) bool { return path == editors[1].settingsPath }
+	t.Cleanup(func() { confirmChange = confirm })
+
+	output := captureOutput(t, func() {
+		if err := configureVSCode(writeOptions{confirm: true}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if got := readTestFile(t, editors[0].settingsPath); got != original {
+		t.Errorf("declined VS Code settings were changed:\n%s", got)
+	}
+	for _, want := range []string{
+		"✓ Configured 1 editor(s):\n  - Cursor\n",
+		"Skipped 1 editor(s); their settings were not changed:\n  - VS Code\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output is missing %q:\n%s", want, output)
+		}
+	}
+}